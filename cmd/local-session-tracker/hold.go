@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	local_session_tracker "github.com/cybozu-go/login-protector/internal/local-session-tracker"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/hold"
+)
+
+// holdRequestTimeout is the timeout of a request to the hold socket.
+const holdRequestTimeout = 10 * time.Second
+
+// runHoldCommand runs the subcommands to manage holds, and returns false if args is not a subcommand.
+// hold and release send the requests to the Unix socket of the running tracker, which takes the owner from the credentials of this process.
+// holds reads the hold file directly, so that it can be used without the tracker.
+func runHoldCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	var err error
+	switch args[0] {
+	case "hold":
+		err = runHold(args[1:])
+	case "release":
+		err = runRelease(args[1:])
+	case "holds":
+		err = runHolds(args[1:])
+	default:
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+func newHoldSocketFlagSet(name string, socket *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(socket, "hold-socket", hold.DefaultSocketPath, "The Unix socket of local-session-tracker to add and release holds.")
+	return fs
+}
+
+// holdSocketClient sends the requests of the hold API to the Unix socket.
+type holdSocketClient struct {
+	client *http.Client
+}
+
+func newHoldSocketClient(socket string) *holdSocketClient {
+	return &holdSocketClient{
+		client: &http.Client{
+			Timeout: holdRequestTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// do sends the request, and decodes the response into out if it is not nil.
+// It returns an error with the message from the tracker unless the response has the expected status code.
+func (c *holdSocketClient) do(method, path string, body any, want int, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, "http://localhost"+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func runHold(args []string) error {
+	var socket, reason string
+	var ttl time.Duration
+	fs := newHoldSocketFlagSet("hold", &socket)
+	fs.StringVar(&reason, "reason", "", "The reason to keep the Pod protected. Required.")
+	fs.DurationVar(&ttl, "ttl", 0, "Duration until the hold expires. If zero, the hold never expires.")
+	fs.Parse(args) //nolint:errcheck
+	if ttl < 0 {
+		return fmt.Errorf("invalid ttl: %s", ttl)
+	}
+
+	req := local_session_tracker.HoldRequest{Reason: reason}
+	if ttl > 0 {
+		req.TTL = ttl.String()
+	}
+	var h common.Hold
+	if err := newHoldSocketClient(socket).do(http.MethodPost, "/holds", req, http.StatusCreated, &h); err != nil {
+		return err
+	}
+	fmt.Println(h.ID)
+	return nil
+}
+
+func runRelease(args []string) error {
+	var socket string
+	fs := newHoldSocketFlagSet("release", &socket)
+	fs.Parse(args) //nolint:errcheck
+	if fs.NArg() == 0 {
+		return errors.New("hold ID is required")
+	}
+
+	client := newHoldSocketClient(socket)
+	for _, id := range fs.Args() {
+		if err := client.do(http.MethodDelete, "/holds/"+id, nil, http.StatusNoContent, nil); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func runHolds(args []string) error {
+	var holdFile string
+	fs := flag.NewFlagSet("holds", flag.ExitOnError)
+	fs.StringVar(&holdFile, "hold-file", hold.DefaultPath, "The file to store holds.")
+	fs.Parse(args) //nolint:errcheck
+
+	holds, err := hold.NewStore(holdFile).List(time.Now())
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(holds)
+}
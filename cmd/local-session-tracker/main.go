@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"flag"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,26 +13,82 @@ import (
 
 	"github.com/cybozu-go/login-protector/internal/common"
 	local_session_tracker "github.com/cybozu-go/login-protector/internal/local-session-tracker"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/config"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
-const httpServerPort = 8080
-
-func newZapLogger() *zap.Logger {
-	logger, err := zap.NewProduction()
+func newZapLogger(level string) *zap.Logger {
+	zapConfig := zap.NewProductionConfig()
+	lvl, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		panic(err)
+	}
+	zapConfig.Level = lvl
+	logger, err := zapConfig.Build()
 	if err != nil {
 		panic(err)
 	}
 	return logger
 }
 
+// loadConfig builds the configuration from the defaults, the configuration file, the environment variables and the flags,
+// in ascending order of precedence.
+func loadConfig(args []string) (*config.Config, string, error) {
+	// The flags are parsed twice, since the configuration file specified by a flag should be read before applying the flags.
+	var configFile string
+	pre := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	pre.SetOutput(io.Discard)
+	pre.StringVar(&configFile, "config", os.Getenv(config.EnvName("config")), "")
+	config.Default().BindFlags(pre)
+	pre.Parse(args) //nolint:errcheck
+
+	cfg := config.Default()
+	if configFile != "" {
+		if err := cfg.Load(configFile); err != nil {
+			return nil, "", err
+		}
+	}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&configFile, "config", configFile,
+		"The YAML configuration file. The flags and the environment variables "+config.EnvPrefix+"<FLAG> take precedence over it.")
+	cfg.BindFlags(fs)
+	if err := config.ApplyEnv(fs, os.LookupEnv); err != nil {
+		return nil, "", err
+	}
+	fs.Parse(args) //nolint:errcheck
+	return cfg, configFile, nil
+}
+
 func main() {
-	logger := newZapLogger()
+	if runHoldCommand(os.Args[1:]) {
+		return
+	}
+
+	cfg, configFile, err := loadConfig(os.Args[1:])
+	if err != nil {
+		newZapLogger("info").Fatal("failed to load configuration", zap.Error(err))
+	}
+	if err := cfg.Validate(); err != nil {
+		newZapLogger("info").Fatal("invalid configuration", zap.String("file", configFile), zap.Error(err))
+	}
+
+	logger := newZapLogger(cfg.LogLevel)
 	defer logger.Sync() //nolint:errcheck
-	logger.Info("starting local-session-tracker...")
+	logger.Info("starting local-session-tracker...",
+		zap.String("config", configFile), zap.String("backend", cfg.Detection.Backend),
+		zap.String("procRoot", cfg.ProcRoot), zap.Bool("simulate", cfg.Simulate))
 
-	local_session_tracker.InitMetrics(logger)
+	trackerConfig, err := cfg.TrackerConfig()
+	if err != nil {
+		logger.Fatal("invalid configuration", zap.Error(err))
+	}
+	trackerConfig.Logger = logger
+	tracker := local_session_tracker.NewTracker(trackerConfig)
+	local_session_tracker.InitMetrics(logger, tracker)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -49,13 +107,82 @@ func main() {
 		}
 	}()
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tracker.Run(ctx, logger)
+	}()
+
+	var clientset kubernetes.Interface
+	if cfg.Push.Enabled || cfg.Auth.TokenReview.Enabled {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			logger.Fatal("failed to load in-cluster configuration", zap.Error(err))
+		}
+		clientset, err = kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			logger.Fatal("failed to create Kubernetes client", zap.Error(err))
+		}
+	}
+	if cfg.Push.Enabled {
+		pusher := local_session_tracker.NewPusher(cfg.PushConfig(), tracker, clientset)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pusher.Run(ctx, logger)
+		}()
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/readyz", handleReadyz)
+	mux.Handle("/readyz", local_session_tracker.NewReadinessHandler(logger, tracker))
+	mux.Handle("/healthz", local_session_tracker.NewLivenessHandler(logger, tracker))
 	mux.Handle("/metrics", promhttp.Handler())
-	mux.Handle("/status", local_session_tracker.NewStatusHandler(logger))
+	mux.Handle("/status", local_session_tracker.NewStatusHandler(logger, tracker))
+	mux.Handle("/status/watch", local_session_tracker.NewWatchHandler(logger, tracker))
+	mux.Handle("/config", newConfigHandler(logger, cfg))
+	var holdServer *http.Server
+	if tracker.HoldStore() != nil {
+		// The holds can be listed over TCP, but can be modified only through the Unix socket.
+		holdHandler := local_session_tracker.NewHoldHandler(logger, tracker)
+		mux.Handle("/holds", holdHandler)
+		mux.Handle("/holds/", holdHandler)
+		if cfg.Holds.Socket != "" {
+			holdMux := http.NewServeMux()
+			holdMux.Handle("/holds", holdHandler)
+			holdMux.Handle("/holds/", holdHandler)
+			holdServer = &http.Server{
+				Handler:     common.NewProxyHTTPHandler(holdMux, logger),
+				ConnContext: local_session_tracker.ConnContextWithPeerCredentials,
+			}
+		}
+	}
+	var handler http.Handler = mux
+	if cfg.Auth.BearerTokenFile != "" {
+		var err error
+		handler, err = local_session_tracker.NewBearerTokenHandler(logger, handler, cfg.Auth.BearerTokenFile)
+		if err != nil {
+			logger.Fatal("failed to read bearer token", zap.Error(err))
+		}
+	}
+	if cfg.Auth.TokenReview.Enabled {
+		reviewer := local_session_tracker.NewTokenReviewer(cfg.TokenReviewConfig(), clientset)
+		handler = local_session_tracker.NewTokenReviewHandler(logger, handler, reviewer)
+	}
+	if cfg.TLS.ClientCAFile != "" {
+		handler = local_session_tracker.NewClientCertHandler(handler)
+	}
 	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", httpServerPort),
-		Handler: common.NewProxyHTTPHandler(mux, logger),
+		Addr:    cfg.ListenAddress,
+		Handler: common.NewProxyHTTPHandler(newRootHandler(mux, handler, cfg.Auth.UnauthenticatedPaths), logger),
+	}
+	if cfg.TLS.CertFile != "" {
+		reloader, err := common.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, func(err error) {
+			logger.Error("failed to reload TLS files", zap.Error(err))
+		})
+		if err != nil {
+			logger.Fatal("failed to load TLS files", zap.Error(err))
+		}
+		server.TLSConfig = local_session_tracker.NewServerTLSConfig(reloader)
 	}
 	wg.Add(1)
 	go func() {
@@ -65,16 +192,75 @@ func main() {
 			<-ctx.Done()
 			server.Shutdown(context.Background()) //nolint:errcheck
 		}()
-		err := server.ListenAndServe()
+		var err error
+		if cfg.TLS.CertFile != "" {
+			// the certificate is given by server.TLSConfig.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != http.ErrServerClosed {
 			logger.Error("failed to start HTTP server", zap.Error(err))
 		}
 	}()
 
+	if holdServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveHoldSocket(ctx, logger, holdServer, cfg.Holds.Socket)
+		}()
+	}
+
 	wg.Wait()
 	logger.Info("termination completed")
 }
 
-func handleReadyz(http.ResponseWriter, *http.Request) {
-	// Nothing to do for now
+// serveHoldSocket serves the hold API on the Unix socket until ctx is canceled.
+// Failing to listen on the socket does not stop tracking the sessions, since the volume for the holds may not be mounted.
+func serveHoldSocket(ctx context.Context, logger *zap.Logger, server *http.Server, path string) {
+	ln, err := local_session_tracker.ListenUnix(path)
+	if err != nil {
+		logger.Error("failed to listen on the hold socket, so the holds cannot be modified through the API", zap.Error(err))
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background()) //nolint:errcheck
+	}()
+	if err := server.Serve(ln); err != http.ErrServerClosed {
+		logger.Error("failed to serve the hold socket", zap.Error(err))
+	}
+}
+
+// newRootHandler returns the handler that serves the unauthenticated paths by mux directly, such as the probes by kubelet,
+// and the other paths by handler.
+func newRootHandler(mux, handler http.Handler, unauthenticatedPaths []string) http.Handler {
+	root := http.NewServeMux()
+	registered := make(map[string]bool)
+	for _, path := range unauthenticatedPaths {
+		if registered[path] {
+			continue
+		}
+		registered[path] = true
+		root.Handle(path, mux)
+	}
+	if !registered["/"] {
+		root.Handle("/", handler)
+	}
+	return root
+}
+
+// newConfigHandler returns the handler showing the effective configuration for debugging.
+func newConfigHandler(logger *zap.Logger, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		out, err := json.Marshal(cfg)
+		if err != nil {
+			logger.Error("failed to marshal", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(out) //nolint:errcheck
+	})
 }
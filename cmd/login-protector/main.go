@@ -2,7 +2,9 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
@@ -10,13 +12,18 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	"github.com/cybozu-go/login-protector/internal/common"
 	"github.com/cybozu-go/login-protector/internal/controller"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -43,6 +50,9 @@ func main() {
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var ttyCheckInterval time.Duration
+	var watchMode string
+	var leaseFailPolicy string
+	var trackerOpts controller.TrackerClientOptions
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -53,6 +63,27 @@ func main() {
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
 	flag.DurationVar(&ttyCheckInterval, "tty-check-interval", 5*time.Second, "interval to check TTY")
+	flag.StringVar(&watchMode, "watch-mode", controller.WatchModePoll,
+		"How to watch local-session-tracker. "+
+			"\"poll\" fetches the status at each tty-check-interval, "+
+			"\"stream\" keeps a stream of the status changes open and falls back to polling while it is not available")
+	flag.StringVar(&leaseFailPolicy, "lease-fail-policy", common.LeaseFailPolicyProtect,
+		"How to treat an expired Lease of local-session-tracker in lease push mode. "+
+			"\"protect\" keeps the Pod protected, and \"release\" considers nobody as logged in. "+
+			"It can be overridden by the "+common.AnnotationKeyLeaseFailPolicy+" annotation of the StatefulSet")
+	flag.StringVar(&trackerOpts.CAFile, "tracker-ca-file", "",
+		"The CA bundle to verify the certificates of local-session-tracker served over HTTPS. If empty, the system roots are used")
+	flag.StringVar(&trackerOpts.CertFile, "tracker-client-cert-file", "", "The client certificate file to connect to local-session-tracker")
+	flag.StringVar(&trackerOpts.KeyFile, "tracker-client-key-file", "", "The private key file of the client certificate")
+	flag.StringVar(&trackerOpts.ServerName, "tracker-server-name", "",
+		"The server name to verify the certificates of local-session-tracker. If empty, the Pod IP is verified")
+	flag.StringVar(&trackerOpts.TokenFile, "tracker-token-file", "",
+		"The file containing the bearer token sent to local-session-tracker over HTTPS. "+
+			"It is read again every minute")
+	flag.StringVar(&trackerOpts.TokenServiceAccount, "tracker-token-service-account", "",
+		"The ServiceAccount of login-protector in the form of <namespace>/<name>. "+
+			"If set, a token of it is requested for each namespace with the audience \""+common.TrackerAudiencePrefix+"<namespace>\" "+
+			"and sent to local-session-tracker over HTTPS")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -61,6 +92,23 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	if watchMode != controller.WatchModePoll && watchMode != controller.WatchModeStream {
+		setupLog.Error(fmt.Errorf("unknown watch mode: %s", watchMode), "invalid flag")
+		os.Exit(1)
+	}
+	if leaseFailPolicy != common.LeaseFailPolicyProtect && leaseFailPolicy != common.LeaseFailPolicyRelease {
+		setupLog.Error(fmt.Errorf("unknown lease fail policy: %s", leaseFailPolicy), "invalid flag")
+		os.Exit(1)
+	}
+	if (trackerOpts.CertFile == "") != (trackerOpts.KeyFile == "") {
+		setupLog.Error(errors.New("both tracker-client-cert-file and tracker-client-key-file should be specified"), "invalid flag")
+		os.Exit(1)
+	}
+	if trackerOpts.TokenFile != "" && trackerOpts.TokenServiceAccount != "" {
+		setupLog.Error(errors.New("tracker-token-file and tracker-token-service-account cannot be specified together"), "invalid flag")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -88,6 +136,14 @@ func main() {
 			SecureServing: secureMetrics,
 			TLSOpts:       tlsOpts,
 		},
+		// Only the Leases of local-session-tracker are cached, not the ones of the nodes and the leader election.
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&coordinationv1.Lease{}: {
+					Label: labels.SelectorFromSet(labels.Set{common.LabelKeyLoginLease: common.ValueTrue}),
+				},
+			},
+		},
 		WebhookServer:          webhookServer,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -99,10 +155,11 @@ func main() {
 	}
 
 	ctx := ctrl.SetupSignalHandler()
+	clientset := kubernetes.NewForConfigOrDie(mgr.GetConfig())
 	setupLog.Info("creating statefulset controller")
 	if err = (&controller.StatefulSetUpdater{
 		Client:    mgr.GetClient(),
-		ClientSet: kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+		ClientSet: clientset,
 		Scheme:    mgr.GetScheme(),
 	}).SetupWithManager(ctx, mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "StatefulSet")
@@ -110,11 +167,19 @@ func main() {
 	}
 
 	setupLog.Info("creating local session watcher")
+	trackerOpts.TokenClient = clientset.CoreV1()
+	trackerClient, err := controller.NewTrackerHTTPClient(trackerOpts, mgr.GetLogger().WithName("LocalSessionWatcher"))
+	if err != nil {
+		setupLog.Error(err, "unable to create the client for local-session-tracker")
+		os.Exit(1)
+	}
 	ch := make(chan event.TypedGenericEvent[*corev1.Pod])
 	watcher := controller.NewLocalSessionWatcher(
 		mgr.GetClient(),
 		mgr.GetLogger().WithName("LocalSessionWatcher"),
 		ttyCheckInterval,
+		watchMode,
+		trackerClient,
 		ch,
 	)
 	err = mgr.Add(watcher)
@@ -132,6 +197,15 @@ func main() {
 		os.Exit(1)
 	}
 
+	setupLog.Info("creating lease controller")
+	if err = (&controller.LeaseReconciler{
+		Client:     mgr.GetClient(),
+		FailPolicy: leaseFailPolicy,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Lease")
+		os.Exit(1)
+	}
+
 	setupLog.Info("creating metrics collector")
 	if err = controller.SetupMetrics(ctx, mgr.GetClient(), mgr.GetLogger().WithName("metrics-collector")); err != nil {
 		setupLog.Error(err, "unable to setup metrics")
@@ -4,11 +4,38 @@ const LabelKeyLoginProtectorProtect = "login-protector.cybozu.io/protect"
 const AnnotationKeyNoPDB = "login-protector.cybozu.io/no-pdb"
 const AnnotationKeyTrackerName = "login-protector.cybozu.io/tracker-name"
 const AnnotationKeyTrackerPort = "login-protector.cybozu.io/tracker-port"
+const AnnotationKeyTrackerScheme = "login-protector.cybozu.io/tracker-scheme"
+const AnnotationKeyIdleTimeout = "login-protector.cybozu.io/idle-timeout"
+const AnnotationKeyProtectDetachedSessions = "login-protector.cybozu.io/protect-detached-sessions"
+const AnnotationKeyPushMode = "login-protector.cybozu.io/push-mode"
+const AnnotationKeyLeaseFailPolicy = "login-protector.cybozu.io/lease-fail-policy"
+const AnnotationKeyLeaseSessions = "login-protector.cybozu.io/sessions"
+const AnnotationKeyLeaseHolds = "login-protector.cybozu.io/holds"
+const LabelKeyLoginLease = "login-protector.cybozu.io/login-lease"
 const AnnotationLoggedIn = "login-protector.cybozu.io/logged-in"
 
 const DefaultTrackerName = "local-session-tracker"
 const DefaultTrackerPort = "8080"
+const DefaultTrackerScheme = "http"
+
+// TrackerAudiencePrefix is the prefix of the audience of the tokens sent to local-session-tracker.
+const TrackerAudiencePrefix = "local-session-tracker:"
+
 const ValueTrue = "true"
 const ValueFalse = "false"
 const KindStatefulSet = "StatefulSet"
 const KindPod = "Pod"
+
+// The values of AnnotationKeyPushMode, that is how local-session-tracker reports the login status by itself.
+const PushModeAnnotation = "annotation"
+const PushModeLease = "lease"
+
+// The values of AnnotationKeyLeaseFailPolicy, that is how an expired Lease is treated.
+const LeaseFailPolicyProtect = "protect"
+const LeaseFailPolicyRelease = "release"
+
+// TrackerAudience returns the audience of the tokens sent to local-session-tracker in the namespace,
+// so that a token sent to a Pod cannot be replayed against the Pods in the other namespaces.
+func TrackerAudience(namespace string) string {
+	return TrackerAudiencePrefix + namespace
+}
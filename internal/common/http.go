@@ -52,3 +52,8 @@ func (rw *proxyHTTPResponseWriter) WriteHeader(statusCode int) {
 	rw.statusCode = statusCode
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
+
+// Unwrap returns the original ResponseWriter so that http.ResponseController can flush the response.
+func (rw *proxyHTTPResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
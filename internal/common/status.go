@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 // Process represents the process information
 type Process struct {
 	// PID represents the process ID
@@ -8,12 +10,173 @@ type Process struct {
 	Command string `json:"command"`
 	// User represents the username of the process owner
 	User string `json:"user"`
+	// TTY represents the name of the controlling terminal such as "pts/3". It is empty for a process without TTY
+	TTY string `json:"tty"`
+	// ContainerID represents the ID of the container the process belongs to
+	ContainerID string `json:"containerID,omitempty"`
+	// Container represents the name of the container the process belongs to
+	Container string `json:"container,omitempty"`
+	// StartTime represents the time the process started
+	StartTime time.Time `json:"startTime"`
+	// AgeSeconds represents how long the process has been running
+	AgeSeconds int64 `json:"ageSeconds"`
+	// Rule represents the name of the rule that matched the process. It is empty if no rule matched
+	Rule string `json:"rule,omitempty"`
+}
+
+const (
+	// SessionKindTTY represents a session associated with a controlling terminal
+	SessionKindTTY = "tty"
+	// SessionKindExec represents a process tree started by `kubectl exec` without a terminal
+	SessionKindExec = "exec"
+	// SessionKindUtmp represents a login recorded in utmp or wtmp
+	SessionKindUtmp = "utmp"
+	// SessionKindSSH represents an SSH session without a pseudo terminal such as scp and rsync
+	SessionKindSSH = "ssh"
+	// SessionKindDetached represents a detached session of a terminal multiplexer such as tmux and screen
+	SessionKindDetached = "detached"
+	// SessionKindNetwork represents a TCP connection to a web terminal or IDE
+	SessionKindNetwork = "network"
+	// SessionKindRule represents the processes without a terminal counted by the include rules
+	SessionKindRule = "rule"
+)
+
+// Connection represents the TCP connection of a network session
+type Connection struct {
+	// LocalPort represents the port number the connection is accepted on
+	LocalPort int `json:"localPort"`
+	// RemoteIP represents the IP address of the peer
+	RemoteIP string `json:"remoteIP"`
+	// RemotePort represents the port number of the peer
+	RemotePort int `json:"remotePort"`
+}
+
+// Multiplexer represents the terminal multiplexer of a detached session
+type Multiplexer struct {
+	// Name represents the name of the multiplexer, either "tmux" or "screen"
+	Name string `json:"name"`
+	// Socket represents the path of the socket of the multiplexer server
+	Socket string `json:"socket,omitempty"`
+}
+
+// SSHConnection represents the SSH connection a session is started from
+type SSHConnection struct {
+	// ClientIP represents the IP address of the SSH client
+	ClientIP string `json:"clientIP,omitempty"`
+	// ClientPort represents the port number of the SSH client
+	ClientPort int `json:"clientPort,omitempty"`
+	// PTY represents whether a pseudo terminal is allocated to the connection
+	PTY bool `json:"pty"`
+}
+
+// Session represents a terminal session, that is a group of processes sharing the session ID and the controlling terminal,
+// an exec session, that is a process tree started by `kubectl exec` without a terminal,
+// a login recorded in utmp, an SSH session without a pseudo terminal, a detached session of a terminal multiplexer,
+// or a TCP connection to a web terminal or IDE
+type Session struct {
+	// ID represents the session ID
+	ID string `json:"id"`
+	// Kind represents how the session is detected, one of SessionKindTTY, SessionKindExec, SessionKindUtmp, SessionKindSSH, SessionKindDetached and SessionKindNetwork
+	Kind string `json:"kind"`
+	// Leader represents the session leader, or the oldest member if the leader is not visible.
+	// For a login recorded in utmp, it is the login process.
+	// For a network session, it is the process owning the socket, which is empty if not visible
+	Leader Process `json:"leader"`
+	// User represents the username of the owner of the leader, or the username recorded in utmp
+	User string `json:"user"`
+	// TTY represents the name of the controlling terminal such as "pts/3". It is empty for a session without TTY
+	TTY string `json:"tty"`
+	// Container represents the name of the container the leader belongs to
+	Container string `json:"container,omitempty"`
+	// Host represents the remote host the user logged in from. It is available only for a login recorded in utmp
+	Host string `json:"host,omitempty"`
+	// SSH represents the SSH connection the session is started from. It is nil if the session is not started by sshd
+	SSH *SSHConnection `json:"ssh,omitempty"`
+	// Multiplexer represents the terminal multiplexer of a detached session
+	Multiplexer *Multiplexer `json:"multiplexer,omitempty"`
+	// Connection represents the TCP connection of a network session
+	Connection *Connection `json:"connection,omitempty"`
+	// Processes represents the list of processes in the session
+	Processes []Process `json:"processes"`
+	// LastInput represents the last time the controlling terminal was read
+	LastInput *time.Time `json:"lastInput,omitempty"`
+	// LastOutput represents the last time the controlling terminal was written
+	LastOutput *time.Time `json:"lastOutput,omitempty"`
+	// IdleSeconds represents how long the session has been idle
+	IdleSeconds int64 `json:"idleSeconds"`
+	// IdleUnknown means that the idle time cannot be determined because the controlling terminal is not accessible.
+	// IdleSeconds is 0 in that case, so that the session is considered active
+	IdleUnknown bool `json:"idleUnknown,omitempty"`
+	// StartTime represents the time the session started
+	StartTime time.Time `json:"startTime"`
+	// AgeSeconds represents how long the session has been running
+	AgeSeconds int64 `json:"ageSeconds"`
+}
+
+// Hold represents a request to keep the Pod protected without any session
+type Hold struct {
+	// ID represents the ID of the hold
+	ID string `json:"id"`
+	// Reason represents why the Pod should be kept
+	Reason string `json:"reason"`
+	// Owner represents who registered the hold
+	Owner string `json:"owner"`
+	// CreatedAt represents the time the hold was registered
+	CreatedAt time.Time `json:"createdAt"`
+	// ExpiresAt represents the time the hold expires. If it is nil, the hold never expires until released
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Process represents the process marked by the environment variable. It is nil for the holds registered by users
+	Process *Process `json:"process,omitempty"`
 }
 
 // TTYStatus represents the TTY status information
 type TTYStatus struct {
-	// Total represents the total number of processes associated with TTY
+	// Total represents the total number of sessions and holds
 	Total int `json:"total"`
-	// Processes represents the list of processes associated with TTY
+	// Processes represents the list of processes in the sessions
 	Processes []Process `json:"processes"`
+	// Sessions represents the list of sessions
+	Sessions []Session `json:"sessions"`
+	// Holds represents the list of holds that have not expired
+	Holds []Hold `json:"holds"`
+	// ExcludedProcesses represents the list of processes ignored by the exclude rules
+	ExcludedProcesses []Process `json:"excludedProcesses,omitempty"`
+	// Version represents the version of the state, which is incremented when the sessions, the holds or the error change
+	Version uint64 `json:"version"`
+	// ScannedAt represents the time the processes were scanned
+	ScannedAt time.Time `json:"scannedAt"`
+	// Error represents a misconfiguration found by the tracker. If it is not empty, the sessions may be missing
+	Error string `json:"error,omitempty"`
+	// OldestStartTime represents the start time of the oldest session
+	OldestStartTime *time.Time `json:"oldestStartTime,omitempty"`
+	// OldestAgeSeconds represents how long the oldest session has been running
+	OldestAgeSeconds int64 `json:"oldestAgeSeconds"`
+}
+
+// CountActiveSessions returns the number of sessions that are not idle for longer than idleTimeout.
+// If idleTimeout is not positive, sessions are counted regardless of the idle time.
+// If countDetached is false, the detached sessions are not counted.
+// The holds are always counted.
+func (s *TTYStatus) CountActiveSessions(idleTimeout time.Duration, countDetached bool) int {
+	if idleTimeout <= 0 && countDetached {
+		return s.Total
+	}
+	return len(s.Holds) + len(s.ActiveSessions(idleTimeout, countDetached))
+}
+
+// ActiveSessions returns the sessions that are not idle for longer than idleTimeout.
+// If idleTimeout is not positive, sessions are returned regardless of the idle time.
+// If countDetached is false, the detached sessions are not returned.
+func (s *TTYStatus) ActiveSessions(idleTimeout time.Duration, countDetached bool) []Session {
+	sessions := make([]Session, 0, len(s.Sessions))
+	for _, session := range s.Sessions {
+		if !countDetached && session.Kind == SessionKindDetached {
+			continue
+		}
+		if idleTimeout > 0 && time.Duration(session.IdleSeconds)*time.Second >= idleTimeout {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions
 }
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountActiveSessions(t *testing.T) {
+	status := &TTYStatus{
+		Total: 4,
+		Sessions: []Session{
+			{ID: "1", Kind: SessionKindTTY, IdleSeconds: 60},
+			{ID: "2", Kind: SessionKindTTY, IdleSeconds: 3600},
+			{ID: "3", Kind: SessionKindDetached, IdleSeconds: 0},
+		},
+		Holds: []Hold{{ID: "hold"}},
+	}
+
+	testCases := []struct {
+		name          string
+		idleTimeout   time.Duration
+		countDetached bool
+		want          int
+	}{
+		{name: "no idle timeout", countDetached: true, want: 4},
+		{name: "idle timeout", idleTimeout: 30 * time.Minute, countDetached: true, want: 3},
+		{name: "idle timeout just reached", idleTimeout: time.Minute, countDetached: true, want: 2},
+		{name: "without detached sessions", countDetached: false, want: 3},
+		{name: "idle timeout without detached sessions", idleTimeout: 30 * time.Minute, want: 2},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := status.CountActiveSessions(tt.idleTimeout, tt.countDetached); got != tt.want {
+				t.Errorf("unexpected count: want %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
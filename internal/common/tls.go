@@ -0,0 +1,124 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CertReloader loads a certificate with its private key and a CA bundle from files,
+// and reloads them when the files are updated, such as a Secret volume updated by kubelet.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	// onError is called when the files fail to be reloaded. The previous ones are used in that case.
+	onError func(error)
+
+	mu    sync.Mutex
+	stamp string
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+}
+
+// NewCertReloader loads the files. The certificate is not loaded if certFile is empty, nor the CA bundle if caFile is empty.
+func NewCertReloader(certFile, keyFile, caFile string, onError func(error)) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		onError:  onError,
+	}
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(stamp); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Certificate returns the current certificate. It returns nil if certFile is empty.
+func (r *CertReloader) Certificate() *tls.Certificate {
+	r.reload()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert
+}
+
+// CAPool returns the current CA bundle. It returns nil if caFile is empty.
+func (r *CertReloader) CAPool() *x509.CertPool {
+	r.reload()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pool
+}
+
+// reload loads the files again if any of them has been modified.
+// The files are checked at every call, which is cheap enough compared to a TLS handshake.
+func (r *CertReloader) reload() {
+	stamp, err := r.fileStamp()
+	if err == nil {
+		r.mu.Lock()
+		modified := stamp != r.stamp
+		r.mu.Unlock()
+		if !modified {
+			return
+		}
+		err = r.load(stamp)
+	}
+	if err != nil && r.onError != nil {
+		r.onError(err)
+	}
+}
+
+func (r *CertReloader) load(stamp string) error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load the certificate %s: %w", r.certFile, err)
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("no certificates found in " + r.caFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stamp = stamp
+	r.cert = cert
+	r.pool = pool
+	return nil
+}
+
+// fileStamp returns the string representing the modification times and the sizes of the files.
+// The symbolic links are followed, since a Secret volume replaces the files by switching a symbolic link.
+func (r *CertReloader) fileStamp() (string, error) {
+	stamps := make([]string, 0, 3)
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		stamps = append(stamps, fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	return strings.Join(stamps, ","), nil
+}
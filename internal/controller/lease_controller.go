@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// LeaseReconciler updates the login status of the Pods from the Leases renewed by local-session-tracker in push mode.
+// A Lease held by someone is considered as logged in until it expires, and an expired Lease is treated according to the fail policy.
+type LeaseReconciler struct {
+	Client client.Client
+	// FailPolicy is the default policy for an expired Lease, either common.LeaseFailPolicyProtect or common.LeaseFailPolicyRelease.
+	// It can be overridden by the annotation of the StatefulSet.
+	FailPolicy string
+}
+
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;update;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+
+func (r *LeaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	lease := &coordinationv1.Lease{}
+	if err := r.Client.Get(ctx, req.NamespacedName, lease); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// The Lease is named after the Pod.
+	pod := &corev1.Pod{}
+	if err := r.Client.Get(ctx, req.NamespacedName, pod); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if pod.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	// The Lease left by the previous Pod of the same name is ignored until it is renewed by the new Pod.
+	for _, ref := range lease.OwnerReferences {
+		if ref.Kind == common.KindPod && ref.UID != pod.UID {
+			return ctrl.Result{}, nil
+		}
+	}
+
+	ownerSts := metav1.GetControllerOf(pod)
+	if ownerSts == nil || ownerSts.Kind != common.KindStatefulSet {
+		return ctrl.Result{}, nil
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ownerSts.Name}, sts); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if sts.Labels[common.LabelKeyLoginProtectorProtect] != common.ValueTrue || sts.Annotations[common.AnnotationKeyPushMode] != common.PushModeLease {
+		return ctrl.Result{}, nil
+	}
+
+	failPolicy := r.FailPolicy
+	if policy, ok := sts.Annotations[common.AnnotationKeyLeaseFailPolicy]; ok {
+		if policy != common.LeaseFailPolicyProtect && policy != common.LeaseFailPolicyRelease {
+			err := fmt.Errorf("invalid %s annotation on StatefulSet %s/%s: %s", common.AnnotationKeyLeaseFailPolicy, sts.Namespace, sts.Name, policy)
+			logger.Error(err, "use the default fail policy", "failPolicy", failPolicy)
+		} else {
+			failPolicy = policy
+		}
+	}
+
+	loggedIn, expiresIn := leaseLoggedIn(lease, failPolicy, time.Now())
+	if expiresIn == 0 && lease.Spec.HolderIdentity != nil {
+		logger.Info("the Lease has expired", "namespace", lease.Namespace, "name", lease.Name, "failPolicy", failPolicy)
+		watcherErrorsCounter.WithLabelValues("lease-reconciler").Inc()
+	}
+
+	value := common.ValueFalse
+	if loggedIn {
+		value = common.ValueTrue
+	}
+	if pod.Annotations[common.AnnotationLoggedIn] != value {
+		logger.Info("notify", "namespace", pod.Namespace, "pod", pod.Name, "current", pod.Annotations[common.AnnotationLoggedIn], "new", value)
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[common.AnnotationLoggedIn] = value
+		if err := r.Client.Update(ctx, pod); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The Lease is checked again when it expires unless renewed.
+	return ctrl.Result{RequeueAfter: expiresIn}, nil
+}
+
+// leaseLoggedIn returns whether someone is logged in according to the Lease, and how long the Lease is valid.
+// A Lease without the holder is not logged in, and the duration is zero if the Lease has been released or expired.
+func leaseLoggedIn(lease *coordinationv1.Lease, failPolicy string, now time.Time) (bool, time.Duration) {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return false, 0
+	}
+	if lease.Spec.RenewTime != nil && lease.Spec.LeaseDurationSeconds != nil {
+		expiresAt := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if now.Before(expiresAt) {
+			return true, expiresAt.Sub(now)
+		}
+	}
+	return failPolicy != common.LeaseFailPolicyRelease, 0
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LeaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	watcherErrorsCounter.WithLabelValues("lease-reconciler").Add(0)
+	pred, err := predicate.LabelSelectorPredicate(metav1.LabelSelector{
+		MatchLabels: map[string]string{common.LabelKeyLoginLease: common.ValueTrue},
+	})
+	if err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&coordinationv1.Lease{}, builder.WithPredicates(pred)).
+		Complete(r)
+}
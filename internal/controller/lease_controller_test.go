@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	appsv1 "k8s.io/api/apps/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func ptrTo[T any](v T) *T {
+	return &v
+}
+
+func newTestLease(holder string, renewedAgo time.Duration, ownerUID types.UID) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "target-sts-0",
+			Labels:    map[string]string{common.LabelKeyLoginLease: common.ValueTrue},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       common.KindPod,
+				Name:       "target-sts-0",
+				UID:        ownerUID,
+			}},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: ptrTo(int32(40)),
+			RenewTime:            &metav1.MicroTime{Time: time.Now().Add(-renewedAgo)},
+		},
+	}
+	if holder != "" {
+		lease.Spec.HolderIdentity = ptrTo(holder)
+	}
+	return lease
+}
+
+func TestLeaseLoggedIn(t *testing.T) {
+	now := time.Now()
+	renewed := &metav1.MicroTime{Time: now.Add(-10 * time.Second)}
+	expired := &metav1.MicroTime{Time: now.Add(-time.Minute)}
+
+	testCases := []struct {
+		name       string
+		spec       coordinationv1.LeaseSpec
+		failPolicy string
+		want       bool
+		wantExpiry time.Duration
+	}{
+		{name: "released", spec: coordinationv1.LeaseSpec{RenewTime: renewed, LeaseDurationSeconds: ptrTo(int32(40))}, failPolicy: common.LeaseFailPolicyProtect},
+		{name: "empty holder", spec: coordinationv1.LeaseSpec{HolderIdentity: ptrTo(""), RenewTime: renewed, LeaseDurationSeconds: ptrTo(int32(40))}, failPolicy: common.LeaseFailPolicyProtect},
+		{name: "held", spec: coordinationv1.LeaseSpec{HolderIdentity: ptrTo("alice"), RenewTime: renewed, LeaseDurationSeconds: ptrTo(int32(40))},
+			failPolicy: common.LeaseFailPolicyRelease, want: true, wantExpiry: 30 * time.Second},
+		{name: "expired with protect", spec: coordinationv1.LeaseSpec{HolderIdentity: ptrTo("alice"), RenewTime: expired, LeaseDurationSeconds: ptrTo(int32(40))},
+			failPolicy: common.LeaseFailPolicyProtect, want: true},
+		{name: "expired with release", spec: coordinationv1.LeaseSpec{HolderIdentity: ptrTo("alice"), RenewTime: expired, LeaseDurationSeconds: ptrTo(int32(40))},
+			failPolicy: common.LeaseFailPolicyRelease},
+		{name: "never renewed", spec: coordinationv1.LeaseSpec{HolderIdentity: ptrTo("alice")}, failPolicy: common.LeaseFailPolicyProtect, want: true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, expiry := leaseLoggedIn(&coordinationv1.Lease{Spec: tt.spec}, tt.failPolicy, now)
+			if got != tt.want || expiry != tt.wantExpiry {
+				t.Errorf("unexpected result: want (%v, %s), got (%v, %s)", tt.want, tt.wantExpiry, got, expiry)
+			}
+		})
+	}
+}
+
+func TestLeaseReconciler(t *testing.T) {
+	testCases := []struct {
+		name        string
+		lease       *coordinationv1.Lease
+		failPolicy  string
+		want        string
+		wantRequeue bool
+	}{
+		{name: "held", lease: newTestLease("alice", 10*time.Second, "uid"), want: common.ValueTrue, wantRequeue: true},
+		{name: "released", lease: newTestLease("", 10*time.Second, "uid"), want: common.ValueFalse},
+		{name: "expired with the default policy", lease: newTestLease("alice", time.Minute, "uid"), want: common.ValueTrue},
+		{name: "expired with release", lease: newTestLease("alice", time.Minute, "uid"), failPolicy: common.LeaseFailPolicyRelease, want: common.ValueFalse},
+		{name: "invalid policy", lease: newTestLease("alice", time.Minute, "uid"), failPolicy: "invalid", want: common.ValueTrue},
+		// the Lease left by the previous Pod is ignored
+		{name: "stale owner", lease: newTestLease("alice", 10*time.Second, "old-uid"), want: ""},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			sts := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "default",
+					Name:        "target-sts",
+					UID:         "sts-uid",
+					Labels:      map[string]string{common.LabelKeyLoginProtectorProtect: common.ValueTrue},
+					Annotations: map[string]string{common.AnnotationKeyPushMode: common.PushModeLease},
+				},
+			}
+			if tt.failPolicy != "" {
+				sts.Annotations[common.AnnotationKeyLeaseFailPolicy] = tt.failPolicy
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+					Name:      "target-sts-0",
+					UID:       "uid",
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1",
+						Kind:       common.KindStatefulSet,
+						Name:       "target-sts",
+						UID:        "sts-uid",
+						Controller: ptrTo(true),
+					}},
+				},
+			}
+			c := fake.NewClientBuilder().WithObjects(sts, pod, tt.lease).Build()
+			r := &LeaseReconciler{Client: c, FailPolicy: common.LeaseFailPolicyProtect}
+
+			ctx := context.Background()
+			key := types.NamespacedName{Namespace: "default", Name: "target-sts-0"}
+			res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			if err != nil {
+				t.Fatalf("failed to reconcile: %v", err)
+			}
+			if (res.RequeueAfter > 0) != tt.wantRequeue {
+				t.Errorf("unexpected result: %+v", res)
+			}
+			if err := c.Get(ctx, key, pod); err != nil {
+				t.Fatal(err)
+			}
+			if v := pod.Annotations[common.AnnotationLoggedIn]; v != tt.want {
+				t.Errorf("unexpected annotation: want %q, got %q", tt.want, v)
+			}
+		})
+	}
+}
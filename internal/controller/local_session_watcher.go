@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/cybozu-go/login-protector/internal/common"
@@ -14,23 +16,44 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
+// The modes of LocalSessionWatcher.
+const (
+	// WatchModePoll fetches the status from every Pod at each interval.
+	WatchModePoll = "poll"
+	// WatchModeStream keeps a stream of the status changes open for every Pod, and fetches the status only when the stream is not available.
+	WatchModeStream = "stream"
+)
+
 type LocalSessionWatcher struct {
-	client   client.Client
-	logger   logr.Logger
-	interval time.Duration
-	channel  chan<- event.TypedGenericEvent[*corev1.Pod]
+	client     client.Client
+	logger     logr.Logger
+	interval   time.Duration
+	mode       string
+	httpClient *http.Client
+	channel    chan<- event.TypedGenericEvent[*corev1.Pod]
+
+	mu      sync.Mutex
+	streams map[types.NamespacedName]*statusStream
 }
 
-func NewLocalSessionWatcher(client client.Client, logger logr.Logger, interval time.Duration, ch chan<- event.TypedGenericEvent[*corev1.Pod]) *LocalSessionWatcher {
+// local-session-tracker authorizes the requests with this permission on the virtual resource when TokenReview is enabled.
+//+kubebuilder:rbac:groups=login-protector.cybozu.io,resources=sessions,verbs=get
+
+func NewLocalSessionWatcher(client client.Client, logger logr.Logger, interval time.Duration, mode string, httpClient *http.Client, ch chan<- event.TypedGenericEvent[*corev1.Pod]) *LocalSessionWatcher {
 	return &LocalSessionWatcher{
-		client:   client,
-		logger:   logger,
-		interval: interval,
-		channel:  ch,
+		client:     client,
+		logger:     logger,
+		interval:   interval,
+		mode:       mode,
+		httpClient: httpClient,
+		channel:    ch,
+		streams:    make(map[types.NamespacedName]*statusStream),
 	}
 }
 
@@ -38,6 +61,9 @@ func (w *LocalSessionWatcher) Start(ctx context.Context) error {
 	ticker := time.NewTicker(w.interval)
 	defer ticker.Stop()
 	watcherErrorsCounter.WithLabelValues("local-session-watcher").Add(0)
+	if w.mode == WatchModeStream {
+		watcherErrorsCounter.WithLabelValues("local-session-stream").Add(0)
+	}
 
 	for {
 		select {
@@ -64,8 +90,14 @@ func (w *LocalSessionWatcher) poll(ctx context.Context) error {
 	}
 
 	errList := make([]error, 0)
+	listed := make(map[types.NamespacedName]bool)
+	listFailed := false
 	// Get all pods that belong to the StatefulSets
 	for _, sts := range stsList.Items {
+		// local-session-tracker in push mode reports the login status of its own Pod.
+		if mode := sts.Annotations[common.AnnotationKeyPushMode]; mode == common.PushModeAnnotation || mode == common.PushModeLease {
+			continue
+		}
 		trackerName := common.DefaultTrackerName
 		if name, ok := sts.Annotations[common.AnnotationKeyTrackerName]; ok {
 			trackerName = name
@@ -74,21 +106,44 @@ func (w *LocalSessionWatcher) poll(ctx context.Context) error {
 		if port, ok := sts.Annotations[common.AnnotationKeyTrackerPort]; ok {
 			trackerPort = port
 		}
+		trackerScheme := common.DefaultTrackerScheme
+		if scheme, ok := sts.Annotations[common.AnnotationKeyTrackerScheme]; ok {
+			if scheme != "http" && scheme != "https" {
+				errList = append(errList, fmt.Errorf("invalid %s annotation on StatefulSet %s/%s: %s", common.AnnotationKeyTrackerScheme, sts.Namespace, sts.Name, scheme))
+				continue
+			}
+			trackerScheme = scheme
+		}
+		var idleTimeout time.Duration
+		if timeout, ok := sts.Annotations[common.AnnotationKeyIdleTimeout]; ok {
+			idleTimeout, err = time.ParseDuration(timeout)
+			if err != nil {
+				errList = append(errList, fmt.Errorf("invalid %s annotation on StatefulSet %s/%s: %w", common.AnnotationKeyIdleTimeout, sts.Namespace, sts.Name, err))
+				continue
+			}
+		}
+		countDetached := sts.Annotations[common.AnnotationKeyProtectDetachedSessions] != common.ValueFalse
 
 		var podList corev1.PodList
 		err = w.client.List(ctx, &podList, client.InNamespace(sts.Namespace), client.MatchingLabels(sts.Spec.Selector.MatchLabels))
 		if err != nil {
 			errList = append(errList, err)
+			listFailed = true
 			continue
 		}
 
 		for _, pod := range podList.Items {
-			err = w.notify(ctx, pod, trackerName, trackerPort)
+			listed[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}] = true
+			err = w.notify(ctx, pod, trackerName, trackerScheme+"://"+net.JoinHostPort(pod.Status.PodIP, trackerPort), idleTimeout, countDetached)
 			if err != nil {
 				errList = append(errList, err)
 			}
 		}
 	}
+	// The streams of the Pods that may still exist are kept when some Pods cannot be listed.
+	if !listFailed {
+		w.removeStreams(listed)
+	}
 	if len(errList) > 0 {
 		return errors.Join(errList...)
 	}
@@ -96,9 +151,10 @@ func (w *LocalSessionWatcher) poll(ctx context.Context) error {
 }
 
 // notify notifies pod-controller that the login status has changed
-func (w *LocalSessionWatcher) notify(ctx context.Context, pod corev1.Pod, trackerName, trackerPort string) error {
-	podIP := pod.Status.PodIP
-
+// If idleTimeout is positive, sessions that have been idle for longer than it are not considered as logged in.
+// If countDetached is false, the detached sessions of terminal multiplexers are not considered as logged in.
+// trackerURL is the base URL of local-session-tracker such as "http://10.0.0.1:8080".
+func (w *LocalSessionWatcher) notify(ctx context.Context, pod corev1.Pod, trackerName, trackerURL string, idleTimeout time.Duration, countDetached bool) error {
 	var container *corev1.Container
 	for _, c := range pod.Spec.Containers {
 		c := c
@@ -112,48 +168,97 @@ func (w *LocalSessionWatcher) notify(ctx context.Context, pod corev1.Pod, tracke
 		return err
 	}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s:%s/status", podIP, trackerPort))
+	if w.mode == WatchModeStream && pod.Status.PodIP != "" {
+		s := w.ensureStream(ctx, &pod, trackerURL, idleTimeout, countDetached)
+		s.updateMu.Lock()
+		defer s.updateMu.Unlock()
+		// The status is fetched only when the stream is not available.
+		if status, ok := s.latest(); ok {
+			return w.update(ctx, pod, &status, idleTimeout, countDetached)
+		}
+	}
+
+	status, err := w.fetchStatus(withPodNamespace(ctx, pod.Namespace), trackerURL+"/status")
 	if err != nil {
 		return err
 	}
+	return w.update(ctx, pod, status, idleTimeout, countDetached)
+}
+
+// fetchStatus fetches the status from local-session-tracker.
+// It gives up after trackerRequestTimeout so that a hung local-session-tracker does not block the poll.
+func (w *LocalSessionWatcher) fetchStatus(ctx context.Context, url string) (*common.TTYStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, trackerRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close() // nolint:errcheck
 
 	status := common.TTYStatus{}
 	statusBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = json.Unmarshal(statusBytes, &status)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &status, nil
+}
+
+// update updates the logged-in annotation of the Pod according to the status, and notifies pod-controller if it has changed.
+func (w *LocalSessionWatcher) update(ctx context.Context, pod corev1.Pod, status *common.TTYStatus, idleTimeout time.Duration, countDetached bool) error {
 	if status.Total < 0 {
-		err = errors.New("broken status")
-		return err
+		return errors.New("broken status")
+	}
+	// The login status is left unchanged since the sessions may be missing.
+	if status.Error != "" {
+		return fmt.Errorf("local-session-tracker in Pod %s/%s reports an error: %s", pod.Namespace, pod.Name, status.Error)
 	}
 
-	if pod.Annotations == nil {
-		pod.Annotations = make(map[string]string)
+	loggedIn := common.ValueTrue
+	if status.CountActiveSessions(idleTimeout, countDetached) == 0 {
+		loggedIn = common.ValueFalse
 	}
 
+	fetched := false
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// The Pod may be older than the one updated by the stream, so it is fetched again on a conflict.
+		if fetched {
+			podUID := pod.UID
+			err := w.client.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, &pod)
+			if err != nil {
+				return err
+			}
+			if pod.UID != podUID {
+				return nil
+			}
+		}
+		fetched = true
+		return w.setLoggedIn(ctx, pod, loggedIn)
+	})
+}
+
+// setLoggedIn sets the logged-in annotation of the Pod, and notifies pod-controller if it has changed.
+func (w *LocalSessionWatcher) setLoggedIn(ctx context.Context, pod corev1.Pod, loggedIn string) error {
 	if pod.Annotations == nil {
 		pod.Annotations = make(map[string]string)
 	}
 	currentLoggedIn := pod.Annotations[common.AnnotationLoggedIn]
-
-	if status.Total == 0 {
-		pod.Annotations[common.AnnotationLoggedIn] = common.ValueFalse
-	} else {
-		pod.Annotations[common.AnnotationLoggedIn] = common.ValueTrue
-	}
-
-	if currentLoggedIn == pod.Annotations[common.AnnotationLoggedIn] {
+	if currentLoggedIn == loggedIn {
 		return nil
 	}
+	pod.Annotations[common.AnnotationLoggedIn] = loggedIn
 
-	w.logger.Info("notify", "namespace", pod.Namespace, "pod", pod.Name, "current", currentLoggedIn, "new", pod.Annotations[common.AnnotationLoggedIn])
+	w.logger.Info("notify", "namespace", pod.Namespace, "pod", pod.Name, "current", currentLoggedIn, "new", loggedIn)
 
-	err = w.client.Update(ctx, &pod)
+	err := w.client.Update(ctx, &pod)
 	if err != nil {
 		return err
 	}
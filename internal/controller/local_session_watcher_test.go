@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestPollSkipsPushMode(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(loggedInStatus()) //nolint:errcheck
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects := make([]client.Object, 0)
+	for _, name := range []string{"pull", "push"} {
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        name,
+				Labels:      map[string]string{common.LabelKeyLoginProtectorProtect: common.ValueTrue},
+				Annotations: map[string]string{common.AnnotationKeyTrackerPort: port},
+			},
+			Spec: appsv1.StatefulSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+			},
+		}
+		if name == "push" {
+			sts.Annotations[common.AnnotationKeyPushMode] = common.PushModeLease
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        name + "-0",
+				Labels:      map[string]string{"app": name},
+				Annotations: map[string]string{common.AnnotationLoggedIn: common.ValueFalse},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: common.DefaultTrackerName}},
+			},
+			Status: corev1.PodStatus{PodIP: "127.0.0.1"},
+		}
+		objects = append(objects, sts, pod)
+	}
+
+	c := fake.NewClientBuilder().WithObjects(objects...).Build()
+	ch := make(chan event.TypedGenericEvent[*corev1.Pod], 10)
+	w := NewLocalSessionWatcher(c, logr.Discard(), time.Second, WatchModePoll, server.Client(), ch)
+	ctx := context.Background()
+	if err := w.poll(ctx); err != nil {
+		t.Fatalf("failed to poll: %v", err)
+	}
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("only the Pod in the pull mode should be polled: %d requests", n)
+	}
+	for name, want := range map[string]string{"pull-0": common.ValueTrue, "push-0": common.ValueFalse} {
+		var pod corev1.Pod
+		if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, &pod); err != nil {
+			t.Fatal(err)
+		}
+		if v := pod.Annotations[common.AnnotationLoggedIn]; v != want {
+			t.Errorf("unexpected annotation of %s: want %q, got %q", name, want, v)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The timings of the streams, which are shortened in the tests.
+var (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+	// streamIdleTimeout is the time to wait for any data before reconnecting.
+	// local-session-tracker sends a keep-alive comment every 15 seconds.
+	streamIdleTimeout = time.Minute
+)
+
+// streamMaxEventSize is the maximum size of a line in the stream.
+const streamMaxEventSize = 16 * 1024 * 1024
+
+// errStreamUnsupported means that local-session-tracker does not serve the stream, because the scan interval is not set.
+var errStreamUnsupported = errors.New("stream is not supported")
+
+// statusStream represents the stream of the status changes from local-session-tracker in a Pod.
+type statusStream struct {
+	cancel context.CancelFunc
+	podUID types.UID
+	url    string
+
+	// updateMu serializes the updates of the Pod by the stream and the poll.
+	updateMu sync.Mutex
+
+	mu            sync.Mutex
+	idleTimeout   time.Duration
+	countDetached bool
+	connected     bool
+	status        *common.TTYStatus
+	receivedAt    time.Time
+}
+
+// settings returns the settings of the StatefulSet that the Pod belongs to.
+func (s *statusStream) settings() (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idleTimeout, s.countDetached
+}
+
+// latest returns the latest status received from the stream.
+// The idle times of the sessions are advanced by the time elapsed since it was received,
+// because the stream does not send the status while only the idle times are increasing.
+// It returns false if the stream is not connected.
+func (s *statusStream) latest() (common.TTYStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.connected || s.status == nil {
+		return common.TTYStatus{}, false
+	}
+	elapsed := int64(time.Since(s.receivedAt).Seconds())
+	status := *s.status
+	status.Sessions = make([]common.Session, len(s.status.Sessions))
+	for i, session := range s.status.Sessions {
+		if !session.IdleUnknown {
+			session.IdleSeconds += elapsed
+		}
+		session.AgeSeconds += elapsed
+		status.Sessions[i] = session
+	}
+	return status, true
+}
+
+func (s *statusStream) received(status *common.TTYStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	s.status = status
+	s.receivedAt = time.Now()
+}
+
+func (s *statusStream) disconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+	s.status = nil
+}
+
+// ensureStream returns the stream for the Pod, and starts it if not started yet.
+// The stream is restarted if the Pod has been recreated or its address has changed.
+func (w *LocalSessionWatcher) ensureStream(ctx context.Context, pod *corev1.Pod, url string, idleTimeout time.Duration, countDetached bool) *statusStream {
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.streams[key]
+	if ok && (s.podUID != pod.UID || s.url != url) {
+		s.cancel()
+		ok = false
+	}
+	if !ok {
+		streamCtx, cancel := context.WithCancel(ctx)
+		s = &statusStream{
+			cancel: cancel,
+			podUID: pod.UID,
+			url:    url,
+		}
+		w.streams[key] = s
+		go w.runStream(streamCtx, key, s)
+	}
+
+	s.mu.Lock()
+	s.idleTimeout = idleTimeout
+	s.countDetached = countDetached
+	s.mu.Unlock()
+	return s
+}
+
+// removeStreams stops the streams for the Pods that are not in the list.
+func (w *LocalSessionWatcher) removeStreams(pods map[types.NamespacedName]bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, s := range w.streams {
+		if !pods[key] {
+			s.cancel()
+			delete(w.streams, key)
+		}
+	}
+}
+
+// runStream keeps the stream open until ctx is canceled.
+// The stream is reconnected with an exponential backoff, which is reset once the stream has been connected.
+// It gives up if local-session-tracker does not support the stream, and the status is fetched by the poll instead.
+// The stream is left registered in that case, so that it is not opened again until the Pod is recreated or its address changes.
+func (w *LocalSessionWatcher) runStream(ctx context.Context, key types.NamespacedName, s *statusStream) {
+	logger := w.logger.WithValues("namespace", key.Namespace, "pod", key.Name)
+	backoff := streamMinBackoff
+	for {
+		connected, err := w.readStream(ctx, key, s)
+		s.disconnected()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errStreamUnsupported) {
+			logger.Info("local-session-tracker does not support the stream; fall back to polling")
+			return
+		}
+		if err != nil {
+			logger.Error(err, "failed to watch the status")
+			watcherErrorsCounter.WithLabelValues("local-session-stream").Inc()
+		}
+		if connected {
+			backoff = streamMinBackoff
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, streamMaxBackoff)
+	}
+}
+
+// readStream reads the Server-Sent Events from /status/watch, and updates the Pod at each event.
+// It returns true if any event has been received.
+func (w *LocalSessionWatcher) readStream(ctx context.Context, key types.NamespacedName, s *statusStream) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(withPodNamespace(ctx, key.Namespace), http.MethodGet, s.url+"/status/watch", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	// local-session-tracker without the scan interval responds 501 Not Implemented.
+	if resp.StatusCode == http.StatusNotImplemented {
+		return false, errStreamUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected response from %s/status/watch: %s", s.url, resp.Status)
+	}
+
+	// The connection may be lost silently, so it is closed if nothing is received for a while.
+	watchdog := time.AfterFunc(streamIdleTimeout, cancel)
+	defer watchdog.Stop()
+
+	connected := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), streamMaxEventSize)
+	data := make([]string, 0)
+	for scanner.Scan() {
+		watchdog.Reset(streamIdleTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// a blank line dispatches the event.
+			if len(data) == 0 {
+				continue
+			}
+			status := &common.TTYStatus{}
+			err := json.Unmarshal([]byte(strings.Join(data, "\n")), status)
+			if err != nil {
+				return connected, err
+			}
+			data = data[:0]
+			connected = true
+			s.received(status)
+			w.handleStreamStatus(ctx, key, s, status)
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// the other fields and the comments are ignored.
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return connected, err
+	}
+	if ctx.Err() != nil && !watchdog.Stop() {
+		return connected, fmt.Errorf("no data from %s/status/watch for %s", s.url, streamIdleTimeout)
+	}
+	return connected, nil
+}
+
+// handleStreamStatus updates the Pod according to the status received from the stream.
+func (w *LocalSessionWatcher) handleStreamStatus(ctx context.Context, key types.NamespacedName, s *statusStream, status *common.TTYStatus) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	var pod corev1.Pod
+	err := w.client.Get(ctx, key, &pod)
+	if err != nil {
+		w.logger.Error(err, "failed to get Pod", "namespace", key.Namespace, "pod", key.Name)
+		watcherErrorsCounter.WithLabelValues("local-session-stream").Inc()
+		return
+	}
+	// the stream will be restarted for the new Pod by the next poll.
+	if pod.UID != s.podUID {
+		return
+	}
+	idleTimeout, countDetached := s.settings()
+	err = w.update(ctx, pod, status, idleTimeout, countDetached)
+	if err != nil {
+		w.logger.Error(err, "failed to update Pod", "namespace", key.Namespace, "pod", key.Name)
+		watcherErrorsCounter.WithLabelValues("local-session-stream").Inc()
+	}
+}
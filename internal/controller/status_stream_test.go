@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func newTestPod(loggedIn string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "target-sts-0",
+			UID:         "uid",
+			Annotations: map[string]string{common.AnnotationLoggedIn: loggedIn},
+		},
+	}
+}
+
+func loggedInStatus() *common.TTYStatus {
+	return &common.TTYStatus{
+		Total:    1,
+		Sessions: []common.Session{{ID: "1", Kind: common.SessionKindTTY, User: "alice", IdleSeconds: 10, AgeSeconds: 20}},
+	}
+}
+
+func TestStatusStreamLatest(t *testing.T) {
+	s := &statusStream{}
+	if _, ok := s.latest(); ok {
+		t.Error("no status should be returned before connected")
+	}
+
+	status := loggedInStatus()
+	s.received(status)
+	s.mu.Lock()
+	s.receivedAt = time.Now().Add(-5 * time.Second)
+	s.mu.Unlock()
+	got, ok := s.latest()
+	if !ok {
+		t.Fatal("the status should be returned while connected")
+	}
+	// the idle time and the age are advanced while nothing is received
+	if session := got.Sessions[0]; session.IdleSeconds != 15 || session.AgeSeconds != 25 {
+		t.Errorf("unexpected session: %+v", session)
+	}
+	if session := status.Sessions[0]; session.IdleSeconds != 10 || session.AgeSeconds != 20 {
+		t.Errorf("the received status should not be modified: %+v", session)
+	}
+
+	// the session whose idle time is unknown stays active
+	status = loggedInStatus()
+	status.Sessions[0].IdleSeconds = 0
+	status.Sessions[0].IdleUnknown = true
+	s.received(status)
+	s.mu.Lock()
+	s.receivedAt = time.Now().Add(-5 * time.Second)
+	s.mu.Unlock()
+	if got, _ := s.latest(); got.Sessions[0].IdleSeconds != 0 {
+		t.Errorf("unknown idle time should not be advanced: %+v", got.Sessions[0])
+	}
+
+	s.disconnected()
+	if _, ok := s.latest(); ok {
+		t.Error("no status should be returned after disconnected")
+	}
+}
+
+func TestRunStream(t *testing.T) {
+	minBackoff, maxBackoff, idleTimeout := streamMinBackoff, streamMaxBackoff, streamIdleTimeout
+	streamMinBackoff, streamMaxBackoff, streamIdleTimeout = 20*time.Millisecond, 80*time.Millisecond, 200*time.Millisecond
+	defer func() {
+		streamMinBackoff, streamMaxBackoff, streamIdleTimeout = minBackoff, maxBackoff, idleTimeout
+	}()
+
+	data, err := json.Marshal(loggedInStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var connectedAt []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		connectedAt = append(connectedAt, time.Now())
+		n := len(connectedAt)
+		mu.Unlock()
+		switch n {
+		case 1, 2:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 3:
+			// an event followed by silence, which makes the watchdog close the stream
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprintf(w, "id: 1\nevent: status\ndata: %s\n\n", data)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			http.Error(w, "not implemented", http.StatusNotImplemented)
+		}
+	}))
+	defer server.Close()
+
+	c := fake.NewClientBuilder().WithObjects(newTestPod(common.ValueFalse)).Build()
+	ch := make(chan event.TypedGenericEvent[*corev1.Pod], 10)
+	w := NewLocalSessionWatcher(c, logr.Discard(), time.Second, WatchModeStream, server.Client(), ch)
+	s := &statusStream{cancel: func() {}, podUID: "uid", url: server.URL}
+	key := types.NamespacedName{Namespace: "default", Name: "target-sts-0"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// runStream returns by itself once the stream turns out to be unsupported
+	w.runStream(ctx, key, s)
+	if ctx.Err() != nil {
+		t.Fatal("the stream should stop on 501")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(connectedAt) != 4 {
+		t.Fatalf("unexpected number of connections: %d", len(connectedAt))
+	}
+	if d := connectedAt[1].Sub(connectedAt[0]); d < streamMinBackoff {
+		t.Errorf("reconnected too early: %s", d)
+	}
+	if d := connectedAt[2].Sub(connectedAt[1]); d < 2*streamMinBackoff {
+		t.Errorf("the backoff should be doubled: %s", d)
+	}
+	if d := connectedAt[3].Sub(connectedAt[2]); d < streamIdleTimeout || d >= streamIdleTimeout+2*streamMinBackoff {
+		t.Errorf("the stream should be closed by the watchdog and the backoff should be reset: %s", d)
+	}
+
+	var pod corev1.Pod
+	if err := c.Get(ctx, key, &pod); err != nil {
+		t.Fatal(err)
+	}
+	if v := pod.Annotations[common.AnnotationLoggedIn]; v != common.ValueTrue {
+		t.Errorf("unexpected annotation: %q", v)
+	}
+	if len(ch) != 1 {
+		t.Errorf("unexpected number of events: %d", len(ch))
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(newTestPod(common.ValueFalse)).Build()
+	ch := make(chan event.TypedGenericEvent[*corev1.Pod], 10)
+	w := NewLocalSessionWatcher(c, logr.Discard(), time.Second, WatchModeStream, http.DefaultClient, ch)
+	ctx := context.Background()
+
+	var stale corev1.Pod
+	if err := c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "target-sts-0"}, &stale); err != nil {
+		t.Fatal(err)
+	}
+	// the stream updates the Pod first
+	if err := w.update(ctx, *stale.DeepCopy(), loggedInStatus(), 0, true); err != nil {
+		t.Fatalf("failed to update: %v", err)
+	}
+	if len(ch) != 1 {
+		t.Fatalf("unexpected number of events: %d", len(ch))
+	}
+
+	// the poll with the stale Pod neither fails nor notifies again
+	if err := w.update(ctx, stale, loggedInStatus(), 0, true); err != nil {
+		t.Errorf("the conflict should be resolved: %v", err)
+	}
+	if len(ch) != 1 {
+		t.Errorf("the change should be notified only once: %d events", len(ch))
+	}
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// tokenReloadInterval is the interval to read the token file again.
+// kubelet rotates a projected ServiceAccount token well before it expires.
+const tokenReloadInterval = time.Minute
+
+// tokenExpirationSeconds is the lifetime of the tokens requested for each namespace,
+// and tokenRenewBefore is how long before the expiration they are requested again.
+const (
+	tokenExpirationSeconds = 3600
+	tokenRenewBefore       = 10 * time.Minute
+)
+
+// trackerRequestTimeout is the timeout of a request to local-session-tracker except the stream.
+const trackerRequestTimeout = 10 * time.Second
+
+// TrackerClientOptions represents how to connect to local-session-tracker.
+type TrackerClientOptions struct {
+	// CAFile is the CA bundle to verify the server certificates. If empty, the system roots are used.
+	CAFile string
+	// CertFile and KeyFile are the client certificate. If empty, no client certificate is sent.
+	CertFile string
+	KeyFile  string
+	// ServerName is the name to verify the server certificates. If empty, the Pod IP is verified.
+	ServerName string
+	// TokenFile is the file containing the bearer token sent to local-session-tracker.
+	// If both TokenFile and TokenServiceAccount are empty, no token is sent.
+	TokenFile string
+	// TokenServiceAccount is the ServiceAccount of login-protector in the form of "<namespace>/<name>".
+	// If set, a token is requested for it by TokenRequest for each namespace of the Pods with the audience given by common.TrackerAudience,
+	// so that a token sent to a Pod cannot be replayed against the Pods in the other namespaces.
+	// It cannot be used together with TokenFile.
+	TokenServiceAccount string
+	// TokenClient is used to request the tokens. It is required if TokenServiceAccount is set.
+	TokenClient corev1client.ServiceAccountsGetter
+}
+
+// NewTrackerHTTPClient returns the HTTP client to connect to local-session-tracker.
+// The files are reloaded when they are updated.
+// The client has no timeout since it also keeps the streams open, so the other requests should be bounded by trackerRequestTimeout.
+func NewTrackerHTTPClient(opts TrackerClientOptions, logger logr.Logger) (*http.Client, error) {
+	reloader, err := common.NewCertReloader(opts.CertFile, opts.KeyFile, opts.CAFile, func(err error) {
+		logger.Error(err, "failed to reload TLS files")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The TLS configuration is built for every connection so that the reloaded files take effect.
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		serverName := opts.ServerName
+		if serverName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			serverName = host
+		}
+		config := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			RootCAs:    reloader.CAPool(),
+			ServerName: serverName,
+		}
+		if cert := reloader.Certificate(); cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		dialer := &tls.Dialer{Config: config}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	var source tokenSource
+	switch {
+	case opts.TokenFile != "" && opts.TokenServiceAccount != "":
+		return nil, errors.New("TokenFile and TokenServiceAccount cannot be used together")
+	case opts.TokenFile != "":
+		fs := &fileTokenSource{path: opts.TokenFile, logger: logger}
+		if _, err := fs.token(context.Background()); err != nil {
+			return nil, err
+		}
+		source = fs
+	case opts.TokenServiceAccount != "":
+		namespace, name, ok := strings.Cut(opts.TokenServiceAccount, "/")
+		if !ok || namespace == "" || name == "" || opts.TokenClient == nil {
+			return nil, fmt.Errorf("invalid ServiceAccount: %q", opts.TokenServiceAccount)
+		}
+		source = &requestTokenSource{
+			client:    opts.TokenClient,
+			namespace: namespace,
+			name:      name,
+			logger:    logger,
+			tokens:    make(map[string]*authenticationv1.TokenRequestStatus),
+		}
+	default:
+		return &http.Client{Transport: transport}, nil
+	}
+	return &http.Client{Transport: &tokenRoundTripper{source: source, next: transport}}, nil
+}
+
+// podNamespaceKey is the key of the context holding the namespace of the Pod that the request is sent to.
+type podNamespaceKey struct{}
+
+// withPodNamespace returns the context for the requests to local-session-tracker in the namespace.
+func withPodNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, podNamespaceKey{}, namespace)
+}
+
+// tokenSource returns the bearer token for the request.
+type tokenSource interface {
+	token(ctx context.Context) (string, error)
+}
+
+// fileTokenSource reads the token from the file, which is read again periodically.
+type fileTokenSource struct {
+	path   string
+	logger logr.Logger
+
+	mu       sync.Mutex
+	value    string
+	loadedAt time.Time
+}
+
+// token returns the current token. If the file fails to be read again, the previous token is returned.
+func (s *fileTokenSource) token(context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.value != "" && time.Since(s.loadedAt) < tokenReloadInterval {
+		return s.value, nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if s.value != "" {
+			s.logger.Error(err, "failed to reload token file")
+			return s.value, nil
+		}
+		return "", err
+	}
+	s.value = strings.TrimSpace(string(data))
+	s.loadedAt = time.Now()
+	return s.value, nil
+}
+
+// requestTokenSource requests the token of the ServiceAccount for each namespace of the Pods.
+type requestTokenSource struct {
+	client    corev1client.ServiceAccountsGetter
+	namespace string
+	name      string
+	logger    logr.Logger
+
+	mu     sync.Mutex
+	tokens map[string]*authenticationv1.TokenRequestStatus
+}
+
+// token returns the token for the namespace in ctx. It is requested again shortly before it expires.
+// If the request fails, the previous token is returned while it is valid.
+func (s *requestTokenSource) token(ctx context.Context) (string, error) {
+	podNamespace, ok := ctx.Value(podNamespaceKey{}).(string)
+	if !ok || podNamespace == "" {
+		return "", errors.New("the namespace of the Pod is unknown")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cached := s.tokens[podNamespace]
+	if cached != nil && time.Until(cached.ExpirationTimestamp.Time) > tokenRenewBefore {
+		return cached.Token, nil
+	}
+	expirationSeconds := int64(tokenExpirationSeconds)
+	tr, err := s.client.ServiceAccounts(s.namespace).CreateToken(ctx, s.name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         []string{common.TrackerAudience(podNamespace)},
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		if cached != nil && time.Now().Before(cached.ExpirationTimestamp.Time) {
+			s.logger.Error(err, "failed to renew token", "namespace", podNamespace)
+			return cached.Token, nil
+		}
+		return "", fmt.Errorf("failed to request token: %w", err)
+	}
+	s.tokens[podNamespace] = &tr.Status
+	return tr.Status.Token, nil
+}
+
+// tokenRoundTripper sets the bearer token to the requests over HTTPS.
+// The token is not sent over plain HTTP, where anyone on the path or a Pod impersonating local-session-tracker could collect it.
+// The server certificates are always verified by the transport, so a request over HTTPS reaches only a trusted server.
+type tokenRoundTripper struct {
+	source tokenSource
+	next   http.RoundTripper
+}
+
+func (t *tokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.next.RoundTrip(req)
+	}
+	token, err := t.source.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	// RoundTrip should not modify the original request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.next.RoundTrip(req)
+}
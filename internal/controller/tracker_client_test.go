@@ -0,0 +1,328 @@
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// testCert is a certificate with its private key for the tests.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate signed by issuer, or a self-signed CA certificate if issuer is nil.
+func newTestCert(t *testing.T, commonName string, serial int64, issuer *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	parent, parentKey := tmpl, key
+	if issuer == nil {
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		tmpl.BasicConstraintsValid = true
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		parent, parentKey = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// writeFile writes the file and sets its modification time, so that the update is detected even if the size is unchanged.
+func writeFile(t *testing.T, name string, data []byte, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrackerHTTPClientTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca1, ca2 := newTestCert(t, "ca-1", 1, nil), newTestCert(t, "ca-2", 2, nil)
+	server1, server2 := newTestCert(t, "server-1", 3, ca1), newTestCert(t, "server-2", 4, ca2)
+	client1, client2 := newTestCert(t, "client-1", 5, ca1), newTestCert(t, "client-2", 6, ca1)
+
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	now := time.Now()
+	writeFile(t, caFile, ca1.certPEM, now)
+	writeFile(t, certFile, client1.certPEM, now)
+	writeFile(t, keyFile, client1.keyPEM, now)
+
+	var serverCert atomic.Pointer[tls.Certificate]
+	setServerCert := func(c *testCert) {
+		cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverCert.Store(&cert)
+	}
+	setServerCert(server1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName)) //nolint:errcheck
+	}))
+	// GetCertificate is not used for the connections without SNI, such as the ones to an IP address.
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				ClientAuth:   tls.RequireAnyClientCert,
+				Certificates: []tls.Certificate{*serverCert.Load()},
+			}, nil
+		},
+	}
+	// the handshake rejected by the client is logged otherwise
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	client, err := NewTrackerHTTPClient(TrackerClientOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile}, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// get makes a new connection, and returns the name of the client certificate seen by the server.
+	get := func() (string, error) {
+		t.Helper()
+		client.CloseIdleConnections()
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if cn, err := get(); err != nil || cn != "client-1" {
+		t.Errorf("unexpected response: %q, %v", cn, err)
+	}
+
+	// the renewed client certificate is used for the next connection
+	writeFile(t, certFile, client2.certPEM, now.Add(time.Minute))
+	writeFile(t, keyFile, client2.keyPEM, now.Add(time.Minute))
+	if cn, err := get(); err != nil || cn != "client-2" {
+		t.Errorf("unexpected response after renewal: %q, %v", cn, err)
+	}
+
+	// the server certificate issued by the new CA is verified after the CA bundle is updated
+	setServerCert(server2)
+	if _, err := get(); err == nil {
+		t.Error("the server certificate issued by the unknown CA should be rejected")
+	}
+	writeFile(t, caFile, ca2.certPEM, now.Add(2*time.Minute))
+	if cn, err := get(); err != nil || cn != "client-2" {
+		t.Errorf("unexpected response after the CA rotation: %q, %v", cn, err)
+	}
+}
+
+// newTokenEchoServer starts the servers responding the Authorization header over HTTPS and HTTP,
+// and returns the CA file to verify the HTTPS one.
+func newTokenEchoServer(t *testing.T) (tlsServer, plainServer *httptest.Server, caFile string) {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization"))) //nolint:errcheck
+	})
+	tlsServer = httptest.NewTLSServer(handler)
+	t.Cleanup(tlsServer.Close)
+	plainServer = httptest.NewServer(handler)
+	t.Cleanup(plainServer.Close)
+	caFile = filepath.Join(t.TempDir(), "ca.crt")
+	writeFile(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw}), time.Now())
+	return tlsServer, plainServer, caFile
+}
+
+// getAuthorization returns the Authorization header seen by the server.
+func getAuthorization(t *testing.T, ctx context.Context, client *http.Client, url string) string {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestTrackerHTTPClientToken(t *testing.T) {
+	tlsServer, plainServer, caFile := newTokenEchoServer(t)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if _, err := NewTrackerHTTPClient(TrackerClientOptions{CAFile: caFile, TokenFile: tokenFile}, logr.Discard()); err == nil {
+		t.Error("missing token file should be an error")
+	}
+	writeFile(t, tokenFile, []byte("token-1\n"), time.Now())
+	client, err := NewTrackerHTTPClient(TrackerClientOptions{CAFile: caFile, TokenFile: tokenFile}, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := client.Transport.(*tokenRoundTripper).source.(*fileTokenSource)
+	// expire makes the token be read again at the next request.
+	expire := func() {
+		source.mu.Lock()
+		source.loadedAt = time.Now().Add(-tokenReloadInterval)
+		source.mu.Unlock()
+	}
+	get := func() string {
+		t.Helper()
+		return getAuthorization(t, context.Background(), client, tlsServer.URL)
+	}
+
+	if v := get(); v != "Bearer token-1" {
+		t.Errorf("unexpected header: %q", v)
+	}
+	// the token is not sent over plain HTTP
+	if v := getAuthorization(t, context.Background(), client, plainServer.URL); v != "" {
+		t.Errorf("the token should not be sent over HTTP: %q", v)
+	}
+
+	// the rotated token is used after the reload interval
+	writeFile(t, tokenFile, []byte("token-2\n"), time.Now())
+	if v := get(); v != "Bearer token-1" {
+		t.Errorf("the token should not be read again within the interval: %q", v)
+	}
+	expire()
+	if v := get(); v != "Bearer token-2" {
+		t.Errorf("unexpected header after rotation: %q", v)
+	}
+
+	// the previous token is kept if the file cannot be read
+	if err := os.Remove(tokenFile); err != nil {
+		t.Fatal(err)
+	}
+	expire()
+	if v := get(); v != "Bearer token-2" {
+		t.Errorf("unexpected header after the file is removed: %q", v)
+	}
+}
+
+func TestTrackerHTTPClientTokenRequest(t *testing.T) {
+	tlsServer, plainServer, caFile := newTokenEchoServer(t)
+
+	clientset := k8sfake.NewSimpleClientset()
+	var requests int
+	var expiration time.Duration
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		requests++
+		create := action.(k8stesting.CreateActionImpl)
+		tr := create.GetObject().(*authenticationv1.TokenRequest)
+		if create.GetNamespace() != "login-protector-system" || create.Name != "controller" {
+			t.Errorf("unexpected ServiceAccount: %s/%s", create.GetNamespace(), create.Name)
+		}
+		if len(tr.Spec.Audiences) != 1 {
+			t.Fatalf("unexpected audiences: %v", tr.Spec.Audiences)
+		}
+		tr.Status.Token = fmt.Sprintf("%s-%d", tr.Spec.Audiences[0], requests)
+		tr.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(expiration))
+		return true, tr, nil
+	})
+
+	opts := TrackerClientOptions{CAFile: caFile, TokenServiceAccount: "login-protector-system/controller", TokenClient: clientset.CoreV1()}
+	if _, err := NewTrackerHTTPClient(TrackerClientOptions{TokenFile: "token", TokenServiceAccount: opts.TokenServiceAccount}, logr.Discard()); err == nil {
+		t.Error("the token file and the ServiceAccount should not be accepted together")
+	}
+	client, err := NewTrackerHTTPClient(opts, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(namespace string) string {
+		t.Helper()
+		return getAuthorization(t, withPodNamespace(context.Background(), namespace), client, tlsServer.URL)
+	}
+
+	expiration = time.Hour
+	// the token is requested for each namespace with its own audience, and reused until it is about to expire
+	if v := get("ns-a"); v != "Bearer local-session-tracker:ns-a-1" {
+		t.Errorf("unexpected header: %q", v)
+	}
+	if v := get("ns-a"); v != "Bearer local-session-tracker:ns-a-1" {
+		t.Errorf("the token should be reused: %q", v)
+	}
+	if v := get("ns-b"); v != "Bearer local-session-tracker:ns-b-2" {
+		t.Errorf("unexpected header for another namespace: %q", v)
+	}
+	if v := getAuthorization(t, withPodNamespace(context.Background(), "ns-a"), client, plainServer.URL); v != "" {
+		t.Errorf("the token should not be sent over HTTP: %q", v)
+	}
+
+	expiration = tokenRenewBefore / 2
+	if v := get("ns-c"); v != "Bearer local-session-tracker:ns-c-3" {
+		t.Errorf("unexpected header: %q", v)
+	}
+	if v := get("ns-c"); v != "Bearer local-session-tracker:ns-c-4" {
+		t.Errorf("the token should be renewed before it expires: %q", v)
+	}
+	if requests != 4 {
+		t.Errorf("unexpected number of requests: %d", requests)
+	}
+
+	// the request without the namespace of the Pod is not sent
+	if _, err := client.Get(tlsServer.URL); err == nil {
+		t.Error("the request without the namespace should fail")
+	}
+}
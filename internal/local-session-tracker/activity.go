@@ -0,0 +1,120 @@
+package local_session_tracker
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+// cpuSample represents the CPU time consumed in a session.
+type cpuSample struct {
+	ticks uint64
+	// changedAt is the last time the CPU time has changed.
+	changedAt time.Time
+	// firstSeenAt is the time the session was observed for the first time.
+	firstSeenAt time.Time
+}
+
+// activityRecorder remembers the CPU time consumed in each session
+// to find out when the session was last active.
+type activityRecorder struct {
+	mu      sync.Mutex
+	samples map[string]cpuSample
+	seen    map[string]cpuSample
+}
+
+func newActivityRecorder() *activityRecorder {
+	return &activityRecorder{
+		samples: make(map[string]cpuSample),
+		seen:    make(map[string]cpuSample),
+	}
+}
+
+// update records the CPU time consumed in each session, and returns
+// the samples including the last time the CPU time of each session has changed.
+// Sessions that are observed for the first time are considered to be active now.
+// The samples are kept only in memory, so all sessions are considered to be active again when the tracker restarts.
+func (r *activityRecorder) update(ticks map[string]uint64, now time.Time) map[string]cpuSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make(map[string]cpuSample, len(ticks))
+	for key, t := range ticks {
+		sample, ok := r.seen[key]
+		if !ok {
+			sample, ok = r.samples[key]
+		}
+		if !ok {
+			sample = cpuSample{
+				ticks:       t,
+				changedAt:   now,
+				firstSeenAt: now,
+			}
+		}
+		if sample.ticks != t {
+			sample.ticks = t
+			sample.changedAt = now
+		}
+		r.seen[key] = sample
+		res[key] = sample
+	}
+	return res
+}
+
+// flush forgets the sessions that were not seen since the last flush, that is the sessions that have been closed.
+func (r *activityRecorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = r.seen
+	r.seen = make(map[string]cpuSample)
+}
+
+// statTTYDevice returns the last access and modification time of the controlling terminal of the process.
+// The device is looked up from the standard file descriptors of the process.
+// If none of them refers to the controlling terminal, nil is returned.
+// denied is true if a file descriptor cannot be inspected without the permission to ptrace the process.
+func (t *Tracker) statTTYDevice(pid int, ttyNumber uint64) (atime, mtime *time.Time, denied bool) {
+	for fd := 0; fd <= 2; fd++ {
+		info, err := os.Stat(t.fs.Path(strconv.Itoa(pid), "fd", strconv.Itoa(fd)))
+		if err != nil {
+			denied = denied || errors.Is(err, fs.ErrPermission)
+			continue
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || st.Rdev != ttyNumber {
+			continue
+		}
+		a := time.Unix(st.Atim.Unix())
+		m := time.Unix(st.Mtim.Unix())
+		return &a, &m, false
+	}
+	return nil, nil, denied
+}
+
+// setIdleSeconds sets the idle time of the session from the last time it was active.
+// If the controlling terminal has not been inspected because of the permission, the idle time is unknown
+// and the session is considered active, since typing into a shell may consume too little CPU time to be noticed.
+func setIdleSeconds(s *common.Session, lastActive, now time.Time, ttyDenied bool) {
+	if ttyDenied && s.LastInput == nil {
+		s.IdleSeconds = 0
+		s.IdleUnknown = true
+		return
+	}
+	s.IdleSeconds = int64(now.Sub(lastActive).Seconds())
+}
+
+// latest returns the latest time among the given times.
+func latest(t time.Time, others ...*time.Time) time.Time {
+	for _, o := range others {
+		if o != nil && o.After(t) {
+			t = *o
+		}
+	}
+	return t
+}
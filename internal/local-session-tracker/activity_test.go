@@ -0,0 +1,80 @@
+package local_session_tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+func TestActivityRecorder(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	r := newActivityRecorder()
+
+	// the sessions observed for the first time are considered to be active now
+	res := r.update(map[string]uint64{"a": 10, "b": 20}, base)
+	r.flush()
+	for _, key := range []string{"a", "b"} {
+		if !res[key].changedAt.Equal(base) || !res[key].firstSeenAt.Equal(base) {
+			t.Errorf("unexpected sample of %s: %+v", key, res[key])
+		}
+	}
+
+	// only the session consuming CPU time becomes active
+	res = r.update(map[string]uint64{"a": 10, "b": 30}, base.Add(time.Minute))
+	r.flush()
+	if !res["a"].changedAt.Equal(base) {
+		t.Errorf("unexpected last activity of a: %s", res["a"].changedAt)
+	}
+	if !res["b"].changedAt.Equal(base.Add(time.Minute)) {
+		t.Errorf("unexpected last activity of b: %s", res["b"].changedAt)
+	}
+
+	// a closed session is forgotten, so a new session with the same key is active when it appears
+	r.update(map[string]uint64{"b": 30}, base.Add(2*time.Minute))
+	r.flush()
+	res = r.update(map[string]uint64{"a": 10}, base.Add(3*time.Minute))
+	if !res["a"].changedAt.Equal(base.Add(3 * time.Minute)) {
+		t.Errorf("unexpected last activity of reopened a: %s", res["a"].changedAt)
+	}
+}
+
+func TestSetIdleSeconds(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lastInput := now.Add(-time.Minute)
+
+	testCases := []struct {
+		name        string
+		lastInput   *time.Time
+		denied      bool
+		wantIdle    int64
+		wantUnknown bool
+	}{
+		{name: "terminal accessible", lastInput: &lastInput, wantIdle: 600},
+		{name: "no terminal on the standard file descriptors", wantIdle: 600},
+		// only the CPU time is known, which may not reflect the input
+		{name: "terminal not accessible", denied: true, wantUnknown: true},
+		{name: "terminal accessible through another process", lastInput: &lastInput, denied: true, wantIdle: 600},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &common.Session{LastInput: tt.lastInput}
+			setIdleSeconds(s, now.Add(-10*time.Minute), now, tt.denied)
+			if s.IdleSeconds != tt.wantIdle || s.IdleUnknown != tt.wantUnknown {
+				t.Errorf("unexpected idle time: %d, unknown: %v", s.IdleSeconds, s.IdleUnknown)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	before := base.Add(-time.Minute)
+	after := base.Add(time.Minute)
+	if got := latest(base, nil, &before); !got.Equal(base) {
+		t.Errorf("unexpected latest: %s", got)
+	}
+	if got := latest(base, &before, &after); !got.Equal(after) {
+		t.Errorf("unexpected latest: %s", got)
+	}
+}
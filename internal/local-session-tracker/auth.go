@@ -0,0 +1,177 @@
+package local_session_tracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The attributes of the SubjectAccessReview for the requests to the tracker.
+// The resource does not exist in the API server, and is granted only to the ServiceAccount of login-protector by default.
+const (
+	AuthGroup    = "login-protector.cybozu.io"
+	AuthResource = "sessions"
+)
+
+// maxAuthCacheEntries is the maximum number of the cached reviews.
+const maxAuthCacheEntries = 1024
+
+// TokenReviewConfig represents how to authenticate and authorize the requests with the Kubernetes API.
+type TokenReviewConfig struct {
+	// Audiences are the audiences the tokens should be issued for.
+	Audiences []string
+	// Namespace and Name are the namespace and the name in the SubjectAccessReview, that is those of the Pod.
+	// If Namespace is empty, the permission in all namespaces is required.
+	Namespace string
+	Name      string
+	// CacheTTL is how long the result of a review is cached.
+	CacheTTL time.Duration
+}
+
+type authCacheEntry struct {
+	authenticated bool
+	allowed       bool
+	expiresAt     time.Time
+}
+
+// TokenReviewer authenticates the bearer tokens by TokenReview, and authorizes the users by SubjectAccessReview.
+type TokenReviewer struct {
+	config TokenReviewConfig
+	client kubernetes.Interface
+
+	mu    sync.Mutex
+	cache map[string]authCacheEntry
+}
+
+// NewTokenReviewer returns a TokenReviewer.
+func NewTokenReviewer(config TokenReviewConfig, client kubernetes.Interface) *TokenReviewer {
+	return &TokenReviewer{
+		config: config,
+		client: client,
+		cache:  make(map[string]authCacheEntry),
+	}
+}
+
+// Review returns whether the token is authenticated, and whether its user is allowed to do verb.
+// The results are cached for CacheTTL, while the errors are not.
+func (r *TokenReviewer) Review(ctx context.Context, token, verb string) (bool, bool, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:]) + "/" + verb
+	now := time.Now()
+
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.authenticated, entry.allowed, nil
+	}
+
+	entry, err := r.review(ctx, token, verb)
+	if err != nil {
+		return false, false, err
+	}
+	entry.expiresAt = now.Add(r.config.CacheTTL)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= maxAuthCacheEntries {
+		for k, e := range r.cache {
+			if !now.Before(e.expiresAt) {
+				delete(r.cache, k)
+			}
+		}
+		if len(r.cache) >= maxAuthCacheEntries {
+			clear(r.cache)
+		}
+	}
+	r.cache[key] = entry
+	return entry.authenticated, entry.allowed, nil
+}
+
+func (r *TokenReviewer) review(ctx context.Context, token, verb string) (authCacheEntry, error) {
+	tr, err := r.client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: r.config.Audiences,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authCacheEntry{}, err
+	}
+	if !tr.Status.Authenticated {
+		return authCacheEntry{}, nil
+	}
+
+	user := tr.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar, err := r.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: r.config.Namespace,
+				Verb:      verb,
+				Group:     AuthGroup,
+				Resource:  AuthResource,
+				Name:      r.config.Name,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			Extra:  extra,
+			UID:    user.UID,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authCacheEntry{}, err
+	}
+	return authCacheEntry{authenticated: true, allowed: sar.Status.Allowed}, nil
+}
+
+// NewTokenReviewHandler returns a handler that rejects the requests whose bearer token is not authenticated
+// or whose user is not authorized by reviewer.
+// The verb of the SubjectAccessReview is "get" for GET, "create" for POST, "delete" for DELETE and "update" for the others.
+func NewTokenReviewHandler(logger *zap.Logger, next http.Handler, reviewer *TokenReviewer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		verb := "update"
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			verb = "get"
+		case http.MethodPost:
+			verb = "create"
+		case http.MethodDelete:
+			verb = "delete"
+		}
+
+		authenticated, allowed, err := reviewer.Review(r.Context(), token, verb)
+		if err != nil {
+			logger.Error("failed to review token", zap.Error(err))
+			http.Error(w, "failed to review token", http.StatusInternalServerError)
+			return
+		}
+		if !authenticated {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
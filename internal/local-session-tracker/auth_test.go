@@ -0,0 +1,158 @@
+package local_session_tracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTokenReviewHandler(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	tokenReviews := 0
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tokenReviews++
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if !slices.Equal(tr.Spec.Audiences, []string{"local-session-tracker"}) {
+			t.Errorf("unexpected audiences: %v", tr.Spec.Audiences)
+		}
+		switch tr.Spec.Token {
+		case "controller":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = "system:serviceaccount:login-protector-system:login-protector-controller-manager"
+		case "other":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = "system:serviceaccount:default:default"
+		}
+		return true, tr, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := sar.Spec.ResourceAttributes
+		if attrs.Group != AuthGroup || attrs.Resource != AuthResource || attrs.Namespace != "default" || attrs.Name != "target-sts-0" {
+			t.Errorf("unexpected resource attributes: %+v", attrs)
+		}
+		sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:login-protector-system:login-protector-controller-manager" &&
+			attrs.Verb == "get"
+		return true, sar, nil
+	})
+
+	reviewer := NewTokenReviewer(TokenReviewConfig{
+		Audiences: []string{"local-session-tracker"},
+		Namespace: "default",
+		Name:      "target-sts-0",
+		CacheTTL:  time.Minute,
+	}, client)
+	handler := NewTokenReviewHandler(zap.NewNop(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) //nolint:errcheck
+	}), reviewer)
+
+	testCases := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodGet, token: "invalid", want: http.StatusUnauthorized},
+		{name: "not allowed", method: http.MethodGet, token: "other", want: http.StatusForbidden},
+		{name: "allowed", method: http.MethodGet, token: "controller", want: http.StatusOK},
+		{name: "not allowed verb", method: http.MethodPost, token: "controller", want: http.StatusForbidden},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/status", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("unexpected status code: want %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	// the results are cached
+	before := tokenReviews
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set("Authorization", "Bearer controller")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", rec.Code)
+	}
+	if tokenReviews != before {
+		t.Errorf("the cached result should be used: %d reviews", tokenReviews-before)
+	}
+}
+
+func TestBearerTokenHandler(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) //nolint:errcheck
+	})
+	if _, err := NewBearerTokenHandler(zap.NewNop(), next, tokenFile); err == nil {
+		t.Error("missing token file should be an error")
+	}
+	// writeToken sets the modification time, so that the update is detected even within the timestamp resolution.
+	now := time.Now()
+	writeToken := func(token string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(tokenFile, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeToken("token-1", now)
+	handler, err := NewBearerTokenHandler(zap.NewNop(), next, tokenFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(token string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Errorf("the request without token should be rejected: %d", code)
+	}
+	if code := get("token-1"); code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", code)
+	}
+
+	// the rotated token is used without restarting
+	writeToken("token-2", now.Add(time.Minute))
+	if code := get("token-1"); code != http.StatusUnauthorized {
+		t.Errorf("the previous token should be rejected: %d", code)
+	}
+	if code := get("token-2"); code != http.StatusOK {
+		t.Errorf("unexpected status code after rotation: %d", code)
+	}
+
+	// the previous token is kept if the file cannot be read
+	if err := os.Remove(tokenFile); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("token-2"); code != http.StatusOK {
+		t.Errorf("unexpected status code after the file is removed: %d", code)
+	}
+}
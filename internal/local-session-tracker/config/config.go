@@ -0,0 +1,457 @@
+// Package config provides the configuration of local-session-tracker read from a YAML file, environment variables and flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	local_session_tracker "github.com/cybozu-go/login-protector/internal/local-session-tracker"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/hold"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/rule"
+	"go.uber.org/zap/zapcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Version is the version of the configuration file.
+const Version = "v1"
+
+// EnvPrefix is the prefix of the environment variables corresponding to the flags.
+// For example, --listen-address can be set by LOCAL_SESSION_TRACKER_LISTEN_ADDRESS.
+const EnvPrefix = "LOCAL_SESSION_TRACKER_"
+
+// Config represents the configuration file of local-session-tracker.
+type Config struct {
+	Version       string          `json:"version"`
+	ListenAddress string          `json:"listenAddress"`
+	TLS           TLSConfig       `json:"tls"`
+	Auth          AuthConfig      `json:"auth"`
+	LogLevel      string          `json:"logLevel"`
+	ProcRoot      string          `json:"procRoot"`
+	Simulate      bool            `json:"simulate"`
+	Scan          ScanConfig      `json:"scan"`
+	Detection     DetectionConfig `json:"detection"`
+	Filters       FiltersConfig   `json:"filters"`
+	Holds         HoldsConfig     `json:"holds"`
+	Push          PushConfig      `json:"push"`
+	// RedactUsers means that the user names are hidden in the responses.
+	RedactUsers bool `json:"redactUsers"`
+}
+
+// TLSConfig represents the certificate to serve HTTPS. If both files are empty, HTTP is served.
+// The files are reloaded when they are updated.
+type TLSConfig struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ClientCAFile is the CA bundle to verify the client certificates. If it is empty, the client certificates are not verified.
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+}
+
+// AuthConfig represents the authentication of the clients.
+type AuthConfig struct {
+	// BearerTokenFile is the file containing the token the clients should send in the Authorization header.
+	// It cannot be used together with TokenReview. If both are disabled, the clients are not authenticated.
+	BearerTokenFile string            `json:"bearerTokenFile,omitempty"`
+	TokenReview     TokenReviewConfig `json:"tokenReview"`
+	// UnauthenticatedPaths are the paths served without authentication nor client certificates, such as the ones for the probes.
+	UnauthenticatedPaths []string `json:"unauthenticatedPaths"`
+}
+
+// TokenReviewConfig represents the authentication by TokenReview and the authorization by SubjectAccessReview.
+type TokenReviewConfig struct {
+	Enabled bool `json:"enabled"`
+	// Audiences are the audiences the tokens should be issued for.
+	// If empty, the audience for the namespace of the Pod is used, which login-protector requests with --tracker-token-service-account.
+	Audiences []string        `json:"audiences,omitempty"`
+	CacheTTL  metav1.Duration `json:"cacheTTL"`
+}
+
+// ScanConfig represents when to scan the processes.
+type ScanConfig struct {
+	// Interval is the interval of the periodic scans. If it is zero, the processes are scanned only on demand.
+	Interval metav1.Duration `json:"interval"`
+	// MaxStaleness is how long a scanned status is served without scanning again.
+	MaxStaleness metav1.Duration `json:"maxStaleness"`
+}
+
+// DetectionConfig represents how to detect sessions.
+type DetectionConfig struct {
+	Backend                 string          `json:"backend"`
+	InitTerminalIdleTimeout metav1.Duration `json:"initTerminalIdleTimeout"`
+	ExecSessions            bool            `json:"execSessions"`
+	SSHWithoutPTY           bool            `json:"sshWithoutPTY"`
+	SessionPorts            []int           `json:"sessionPorts,omitempty"`
+}
+
+// FiltersConfig represents which processes are counted.
+type FiltersConfig struct {
+	Containers         []string `json:"containers,omitempty"`
+	IgnoreExecCommands []string `json:"ignoreExecCommands,omitempty"`
+	// RulesFile is the YAML file of the rules. It cannot be used together with Rules.
+	RulesFile string      `json:"rulesFile,omitempty"`
+	Rules     rule.Config `json:"rules"`
+}
+
+// HoldsConfig represents the holds.
+type HoldsConfig struct {
+	File string `json:"file"`
+	// Socket is the Unix socket to add and release the holds. If it is empty, the holds cannot be modified through the HTTP API.
+	Socket string `json:"socket"`
+	Env    string `json:"env,omitempty"`
+}
+
+// PushConfig represents the push mode, in which the tracker updates the login status of its own Pod.
+type PushConfig struct {
+	Enabled bool `json:"enabled"`
+	// Method is how to report the login status, either "annotation" or "lease".
+	Method string `json:"method"`
+	// PodNamespace, PodName and PodUID should be set from the downward API.
+	PodNamespace string `json:"podNamespace,omitempty"`
+	PodName      string `json:"podName,omitempty"`
+	PodUID       string `json:"podUID,omitempty"`
+	// LeaseDuration is how long the Lease is valid after it is renewed. It should be longer than the scan interval.
+	LeaseDuration metav1.Duration `json:"leaseDuration"`
+	// IdleTimeout and ProtectDetachedSessions correspond to the annotations of the StatefulSet in the pull mode.
+	IdleTimeout             metav1.Duration `json:"idleTimeout"`
+	ProtectDetachedSessions bool            `json:"protectDetachedSessions"`
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Version:       Version,
+		ListenAddress: ":8080",
+		LogLevel:      "info",
+		ProcRoot:      procfs.DefaultRoot,
+		Auth: AuthConfig{
+			TokenReview: TokenReviewConfig{
+				CacheTTL: metav1.Duration{Duration: time.Minute},
+			},
+			UnauthenticatedPaths: []string{"/readyz", "/healthz"},
+		},
+		Scan: ScanConfig{
+			MaxStaleness: metav1.Duration{Duration: 5 * time.Second},
+		},
+		Detection: DetectionConfig{
+			Backend:                 local_session_tracker.BackendProc,
+			InitTerminalIdleTimeout: metav1.Duration{Duration: 5 * time.Minute},
+		},
+		Holds: HoldsConfig{
+			File:   hold.DefaultPath,
+			Socket: hold.DefaultSocketPath,
+		},
+		Push: PushConfig{
+			Method:                  common.PushModeAnnotation,
+			LeaseDuration:           metav1.Duration{Duration: 40 * time.Second},
+			ProtectDetachedSessions: true,
+		},
+	}
+}
+
+// Load reads the configuration file over c. The fields missing in the file are left unchanged.
+func (c *Config) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// The version is required in the file.
+	c.Version = ""
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// BindFlags defines the flags to override the fields of c. The current values of c are used as the defaults.
+func (c *Config) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ListenAddress, "listen-address", c.ListenAddress, "The address the HTTP server binds to.")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert-file", c.TLS.CertFile, "The certificate file to serve HTTPS.")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key-file", c.TLS.KeyFile, "The private key file to serve HTTPS.")
+	fs.StringVar(&c.TLS.ClientCAFile, "tls-client-ca-file", c.TLS.ClientCAFile,
+		"The CA bundle to verify the client certificates. If set, the requests without a verified client certificate are rejected except for the probes.")
+	fs.StringVar(&c.Auth.BearerTokenFile, "auth-bearer-token-file", c.Auth.BearerTokenFile,
+		"The file containing the bearer token the clients should send. If empty, the clients are not authenticated.")
+	fs.BoolVar(&c.Auth.TokenReview.Enabled, "auth-token-review", c.Auth.TokenReview.Enabled,
+		"If set, the bearer tokens are authenticated by TokenReview and the users are authorized by SubjectAccessReview.")
+	fs.Var((*stringList)(&c.Auth.TokenReview.Audiences), "auth-audiences",
+		"Comma-separated list of the audiences the tokens should be issued for. If empty, \""+common.TrackerAudiencePrefix+"<pod-namespace>\" is used.")
+	fs.DurationVar(&c.Auth.TokenReview.CacheTTL.Duration, "auth-cache-ttl", c.Auth.TokenReview.CacheTTL.Duration,
+		"Duration for which the result of a review is cached.")
+	fs.Var((*stringList)(&c.Auth.UnauthenticatedPaths), "auth-unauthenticated-paths",
+		"Comma-separated list of the paths served without authentication nor client certificates.")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "The log level, one of \"debug\", \"info\", \"warn\" and \"error\".")
+	fs.StringVar(&c.ProcRoot, "proc-root", c.ProcRoot, "The directory where procfs is mounted.")
+	fs.BoolVar(&c.Simulate, "simulate", c.Simulate,
+		"If set, serve the status from a directory tree of fake /proc entries specified by --proc-root. "+
+			"The current time is derived from the btime in <proc-root>/stat and <proc-root>/uptime.")
+	fs.DurationVar(&c.Scan.Interval.Duration, "scan-interval", c.Scan.Interval.Duration,
+		"Interval to scan the processes periodically. If zero, the processes are scanned only on demand.")
+	fs.DurationVar(&c.Scan.MaxStaleness.Duration, "max-staleness", c.Scan.MaxStaleness.Duration,
+		"Duration for which a scanned status is served without scanning the processes again. If zero, every request scans them.")
+	fs.StringVar(&c.Detection.Backend, "backend", c.Detection.Backend,
+		"The backend to detect sessions. "+
+			"\"proc\" scans the controlling terminals of the processes, and \"utmp\" reads the login records in utmp or wtmp of the containers.")
+	fs.DurationVar(&c.Detection.InitTerminalIdleTimeout.Duration, "init-terminal-idle-timeout", c.Detection.InitTerminalIdleTimeout.Duration,
+		"Duration for which the terminal of a container started with `tty: true` is counted after the last input. "+
+			"If zero, such terminals are never counted.")
+	fs.BoolVar(&c.Detection.ExecSessions, "detect-exec-sessions", c.Detection.ExecSessions,
+		"If set, the process trees started by `kubectl exec` without a terminal are also counted as sessions.")
+	fs.BoolVar(&c.Detection.SSHWithoutPTY, "detect-ssh-without-pty", c.Detection.SSHWithoutPTY,
+		"If set, the SSH sessions without a pseudo terminal such as scp and rsync are also counted as sessions.")
+	fs.Var((*intList)(&c.Detection.SessionPorts), "session-ports",
+		"Comma-separated list of local TCP ports such as the ones of web terminals and IDEs. "+
+			"Each established connection to them is counted as a session.")
+	fs.Var((*stringList)(&c.Filters.Containers), "containers",
+		"Comma-separated list of container names whose sessions are counted. If empty, sessions in all containers are counted.")
+	fs.Var((*stringList)(&c.Filters.IgnoreExecCommands), "ignore-exec-commands",
+		"Comma-separated list of command names whose process trees are not counted as exec sessions, such as the commands of exec probes.")
+	fs.StringVar(&c.Filters.RulesFile, "rules-file", c.Filters.RulesFile,
+		"The YAML file of the CEL rules to include or exclude processes. If empty, no rules are applied.")
+	fs.StringVar(&c.Holds.File, "hold-file", c.Holds.File,
+		"The file to store holds, which should be in a volume shared among the containers. If empty, holds are disabled.")
+	fs.StringVar(&c.Holds.Socket, "hold-socket", c.Holds.Socket,
+		"The Unix socket to serve the hold API to the other containers, which should be in a volume shared among them. "+
+			"If empty, the holds cannot be added nor released through the API.")
+	fs.StringVar(&c.Holds.Env, "hold-env", c.Holds.Env,
+		"Environment variable marking the processes that keep the Pod protected, either \"NAME\" or \"NAME=VALUE\". "+
+			"If empty, the environment variables of the processes are not read.")
+	fs.BoolVar(&c.Push.Enabled, "push", c.Push.Enabled,
+		"If set, report the login status of the Pod specified by --pod-namespace and --pod-name instead of being polled by login-protector. "+
+			"It requires --scan-interval.")
+	fs.StringVar(&c.Push.Method, "push-method", c.Push.Method,
+		"How to report the login status in push mode. "+
+			"\"annotation\" patches the annotation of the Pod, and \"lease\" keeps renewing the Lease named after the Pod while someone is logged in.")
+	fs.StringVar(&c.Push.PodNamespace, "pod-namespace", c.Push.PodNamespace,
+		"The namespace of the Pod the tracker runs in, used in push mode and in the SubjectAccessReview.")
+	fs.StringVar(&c.Push.PodName, "pod-name", c.Push.PodName,
+		"The name of the Pod the tracker runs in, used in push mode and in the SubjectAccessReview.")
+	fs.StringVar(&c.Push.PodUID, "pod-uid", c.Push.PodUID,
+		"The UID of the Pod the tracker runs in. If set, the Lease is owned by the Pod so that it is deleted together.")
+	fs.DurationVar(&c.Push.LeaseDuration.Duration, "push-lease-duration", c.Push.LeaseDuration.Duration,
+		"Duration for which the Lease is valid after it is renewed. It should be longer than --scan-interval.")
+	fs.DurationVar(&c.Push.IdleTimeout.Duration, "push-idle-timeout", c.Push.IdleTimeout.Duration,
+		"Duration after which an idle session is no longer considered as logged in, used in push mode. If zero, idle sessions are always considered as logged in.")
+	fs.BoolVar(&c.Push.ProtectDetachedSessions, "push-protect-detached-sessions", c.Push.ProtectDetachedSessions,
+		"If set, the detached sessions of tmux and screen are considered as logged in, used in push mode.")
+	fs.BoolVar(&c.RedactUsers, "redact-users", c.RedactUsers, "If set, the user names are hidden in the responses.")
+}
+
+// ApplyEnv sets the flags from the corresponding environment variables.
+// It should be called before parsing the command line so that the flags take precedence.
+func ApplyEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		value, ok := lookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// EnvName returns the name of the environment variable corresponding to the flag.
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Validate checks the configuration and returns all the problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Version != Version {
+		errs = append(errs, fmt.Errorf("unsupported version: %q", c.Version))
+	}
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listenAddress: %w", err))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("both tls.certFile and tls.keyFile should be specified"))
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		errs = append(errs, errors.New("tls.clientCAFile requires tls.certFile and tls.keyFile"))
+	}
+	if c.Auth.TokenReview.Enabled {
+		if c.Auth.BearerTokenFile != "" {
+			errs = append(errs, errors.New("auth.bearerTokenFile and auth.tokenReview cannot be enabled together"))
+		}
+		if c.Push.PodNamespace == "" || c.Push.PodName == "" {
+			errs = append(errs, errors.New("push.podNamespace and push.podName are required for auth.tokenReview"))
+		}
+		if c.Auth.TokenReview.CacheTTL.Duration < 0 {
+			errs = append(errs, errors.New("auth.tokenReview.cacheTTL should not be negative"))
+		}
+	}
+	for _, path := range c.Auth.UnauthenticatedPaths {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("invalid path in auth.unauthenticatedPaths: %q", path))
+		}
+	}
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("invalid logLevel: %q", c.LogLevel))
+	}
+	if c.Scan.Interval.Duration < 0 {
+		errs = append(errs, errors.New("scan.interval should not be negative"))
+	}
+	if c.Scan.MaxStaleness.Duration < 0 {
+		errs = append(errs, errors.New("scan.maxStaleness should not be negative"))
+	}
+	if c.Detection.Backend != local_session_tracker.BackendProc && c.Detection.Backend != local_session_tracker.BackendUtmp {
+		errs = append(errs, fmt.Errorf("unknown detection.backend: %q", c.Detection.Backend))
+	}
+	if c.Detection.InitTerminalIdleTimeout.Duration < 0 {
+		errs = append(errs, errors.New("detection.initTerminalIdleTimeout should not be negative"))
+	}
+	for _, port := range c.Detection.SessionPorts {
+		if port <= 0 || port > 65535 {
+			errs = append(errs, fmt.Errorf("invalid port in detection.sessionPorts: %d", port))
+		}
+	}
+	if c.Filters.RulesFile != "" && (len(c.Filters.Rules.Include) > 0 || len(c.Filters.Rules.Exclude) > 0) {
+		errs = append(errs, errors.New("filters.rulesFile and filters.rules cannot be specified together"))
+	} else if _, err := c.rules(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid rules: %w", err))
+	}
+	if c.Push.Enabled {
+		if c.Push.PodNamespace == "" || c.Push.PodName == "" {
+			errs = append(errs, errors.New("push.podNamespace and push.podName are required in push mode"))
+		}
+		if c.Scan.Interval.Duration <= 0 {
+			errs = append(errs, errors.New("scan.interval is required in push mode"))
+		}
+		if c.Push.IdleTimeout.Duration < 0 {
+			errs = append(errs, errors.New("push.idleTimeout should not be negative"))
+		}
+		switch c.Push.Method {
+		case common.PushModeAnnotation:
+		case common.PushModeLease:
+			if c.Push.LeaseDuration.Duration <= c.Scan.Interval.Duration {
+				errs = append(errs, errors.New("push.leaseDuration should be longer than scan.interval"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown push.method: %q", c.Push.Method))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rules compiles the rules. It returns nil if no rules are configured.
+func (c *Config) rules() (*rule.Engine, error) {
+	if c.Filters.RulesFile != "" {
+		return rule.Load(c.Filters.RulesFile)
+	}
+	if len(c.Filters.Rules.Include) == 0 && len(c.Filters.Rules.Exclude) == 0 {
+		return nil, nil
+	}
+	return rule.New(c.Filters.Rules)
+}
+
+// TrackerConfig returns the configuration of the tracker. c should be validated in advance.
+func (c *Config) TrackerConfig() (local_session_tracker.Config, error) {
+	rules, err := c.rules()
+	if err != nil {
+		return local_session_tracker.Config{}, err
+	}
+	return local_session_tracker.Config{
+		ProcRoot:                c.ProcRoot,
+		Backend:                 c.Detection.Backend,
+		Simulated:               c.Simulate,
+		Containers:              c.Filters.Containers,
+		InitTerminalIdleTimeout: c.Detection.InitTerminalIdleTimeout.Duration,
+		DetectExecSessions:      c.Detection.ExecSessions,
+		IgnoredExecCommands:     c.Filters.IgnoreExecCommands,
+		DetectSSHWithoutPTY:     c.Detection.SSHWithoutPTY,
+		SessionPorts:            c.Detection.SessionPorts,
+		HoldFile:                c.Holds.File,
+		HoldEnv:                 c.Holds.Env,
+		Rules:                   rules,
+		RedactUsers:             c.RedactUsers,
+		MaxStaleness:            c.Scan.MaxStaleness.Duration,
+		ScanInterval:            c.Scan.Interval.Duration,
+	}, nil
+}
+
+// TokenReviewConfig returns the configuration of the authentication by TokenReview. c should be validated in advance.
+func (c *Config) TokenReviewConfig() local_session_tracker.TokenReviewConfig {
+	audiences := c.Auth.TokenReview.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{common.TrackerAudience(c.Push.PodNamespace)}
+	}
+	return local_session_tracker.TokenReviewConfig{
+		Audiences: audiences,
+		Namespace: c.Push.PodNamespace,
+		Name:      c.Push.PodName,
+		CacheTTL:  c.Auth.TokenReview.CacheTTL.Duration,
+	}
+}
+
+// PushConfig returns the configuration of the push mode. c should be validated in advance.
+func (c *Config) PushConfig() local_session_tracker.PushConfig {
+	return local_session_tracker.PushConfig{
+		Method:                  c.Push.Method,
+		PodNamespace:            c.Push.PodNamespace,
+		PodName:                 c.Push.PodName,
+		PodUID:                  c.Push.PodUID,
+		IdleTimeout:             c.Push.IdleTimeout.Duration,
+		ProtectDetachedSessions: c.Push.ProtectDetachedSessions,
+		Interval:                c.Scan.Interval.Duration,
+		LeaseDuration:           c.Push.LeaseDuration.Duration,
+	}
+}
+
+// stringList is a flag.Value of a comma-separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	if value != "" {
+		*l = strings.Split(value, ",")
+	}
+	return nil
+}
+
+// intList is a flag.Value of a comma-separated list of integers.
+type intList []int
+
+func (l *intList) String() string {
+	if l == nil {
+		return ""
+	}
+	values := make([]string, len(*l))
+	for i, v := range *l {
+		values[i] = strconv.Itoa(v)
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *intList) Set(value string) error {
+	*l = nil
+	if value == "" {
+		return nil
+	}
+	for _, v := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, i)
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/hold"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/rule"
+)
+
+func TestLoad(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Load(filepath.Join("testdata", "config.yaml")); err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if cfg.ListenAddress != "127.0.0.1:9090" || cfg.LogLevel != "debug" || !cfg.RedactUsers {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.Detection.InitTerminalIdleTimeout.Duration != 10*time.Minute || !cfg.Detection.ExecSessions ||
+		len(cfg.Detection.SessionPorts) != 1 || cfg.Detection.SessionPorts[0] != 8443 {
+		t.Errorf("unexpected detection: %+v", cfg.Detection)
+	}
+	// the fields missing in the file keep the defaults
+	if cfg.Holds.File != hold.DefaultPath || cfg.Holds.Env != "LOGIN_PROTECTOR_HOLD=1" {
+		t.Errorf("unexpected holds: %+v", cfg.Holds)
+	}
+
+	if cfg.Scan.Interval.Duration != 10*time.Second || cfg.Scan.MaxStaleness.Duration != 15*time.Second {
+		t.Errorf("unexpected scan: %+v", cfg.Scan)
+	}
+
+	tc, err := cfg.TrackerConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tc.Rules == nil || len(tc.Containers) != 1 || tc.Containers[0] != "main" || !tc.RedactUsers || tc.ScanInterval != 10*time.Second {
+		t.Errorf("unexpected tracker config: %+v", tc)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "no version",
+			content: "listenAddress: :9090\n",
+		},
+		{
+			name:    "unknown field",
+			content: "version: v1\nlistenAdress: :9090\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := Default()
+			err := cfg.Load(path)
+			if err == nil {
+				err = cfg.Validate()
+			}
+			if err == nil {
+				t.Error("invalid file should be an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{
+			name:   "default",
+			modify: func(*Config) {},
+		},
+		{
+			name:    "listen address",
+			modify:  func(c *Config) { c.ListenAddress = "8080" },
+			wantErr: "invalid listenAddress",
+		},
+		{
+			name:    "tls",
+			modify:  func(c *Config) { c.TLS.CertFile = "tls.crt" },
+			wantErr: "tls.keyFile",
+		},
+		{
+			name:    "client CA without certificate",
+			modify:  func(c *Config) { c.TLS.ClientCAFile = "ca.crt" },
+			wantErr: "tls.clientCAFile requires",
+		},
+		{
+			name: "token review",
+			modify: func(c *Config) {
+				c.Auth.TokenReview.Enabled = true
+				c.Push.PodNamespace = "default"
+				c.Push.PodName = "target-sts-0"
+			},
+		},
+		{
+			name: "token review and bearer token",
+			modify: func(c *Config) {
+				c.Auth.TokenReview.Enabled = true
+				c.Auth.BearerTokenFile = "token"
+			},
+			wantErr: "cannot be enabled together",
+		},
+		{
+			name: "token review without pod",
+			modify: func(c *Config) {
+				c.Auth.TokenReview.Enabled = true
+			},
+			wantErr: "push.podNamespace and push.podName are required for auth.tokenReview",
+		},
+		{
+			name:    "unauthenticated path",
+			modify:  func(c *Config) { c.Auth.UnauthenticatedPaths = []string{"metrics"} },
+			wantErr: "invalid path in auth.unauthenticatedPaths",
+		},
+		{
+			name:    "log level",
+			modify:  func(c *Config) { c.LogLevel = "verbose" },
+			wantErr: "invalid logLevel",
+		},
+		{
+			name:    "scan interval",
+			modify:  func(c *Config) { c.Scan.Interval.Duration = -time.Second },
+			wantErr: "scan.interval",
+		},
+		{
+			name:    "backend",
+			modify:  func(c *Config) { c.Detection.Backend = "wtmp" },
+			wantErr: "unknown detection.backend",
+		},
+		{
+			name:    "session port",
+			modify:  func(c *Config) { c.Detection.SessionPorts = []int{65536} },
+			wantErr: "invalid port",
+		},
+		{
+			name: "rules file and rules",
+			modify: func(c *Config) {
+				c.Filters.RulesFile = "rules.yaml"
+				c.Filters.Rules.Exclude = []rule.Rule{{Name: "sleep", Expression: `comm == "sleep"`}}
+			},
+			wantErr: "cannot be specified together",
+		},
+		{
+			name: "invalid rule",
+			modify: func(c *Config) {
+				c.Filters.Rules.Exclude = []rule.Rule{{Name: "sleep", Expression: "comm"}}
+			},
+			wantErr: "invalid rules",
+		},
+		{
+			name: "push",
+			modify: func(c *Config) {
+				c.Push.Enabled = true
+				c.Push.PodNamespace = "default"
+				c.Push.PodName = "target-sts-0"
+				c.Scan.Interval.Duration = 10 * time.Second
+			},
+		},
+		{
+			name: "push without pod",
+			modify: func(c *Config) {
+				c.Push.Enabled = true
+				c.Scan.Interval.Duration = 10 * time.Second
+			},
+			wantErr: "push.podNamespace and push.podName are required",
+		},
+		{
+			name: "push without scan interval",
+			modify: func(c *Config) {
+				c.Push.Enabled = true
+				c.Push.PodNamespace = "default"
+				c.Push.PodName = "target-sts-0"
+			},
+			wantErr: "scan.interval is required",
+		},
+		{
+			name: "push lease",
+			modify: func(c *Config) {
+				c.Push.Enabled = true
+				c.Push.Method = "lease"
+				c.Push.PodNamespace = "default"
+				c.Push.PodName = "target-sts-0"
+				c.Scan.Interval.Duration = 10 * time.Second
+			},
+		},
+		{
+			name: "short lease duration",
+			modify: func(c *Config) {
+				c.Push.Enabled = true
+				c.Push.Method = "lease"
+				c.Push.PodNamespace = "default"
+				c.Push.PodName = "target-sts-0"
+				c.Scan.Interval.Duration = time.Minute
+			},
+			wantErr: "push.leaseDuration should be longer than scan.interval",
+		},
+		{
+			name: "push method",
+			modify: func(c *Config) {
+				c.Push.Enabled = true
+				c.Push.Method = "configmap"
+				c.Push.PodNamespace = "default"
+				c.Push.PodName = "target-sts-0"
+				c.Scan.Interval.Duration = 10 * time.Second
+			},
+			wantErr: "unknown push.method",
+		},
+		{
+			name: "multiple errors",
+			modify: func(c *Config) {
+				c.LogLevel = "verbose"
+				c.Detection.Backend = "wtmp"
+			},
+			wantErr: "invalid logLevel: \"verbose\"\nunknown detection.backend",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			tc.modify(cfg)
+			err := cfg.Validate()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTokenReviewConfig(t *testing.T) {
+	cfg := Default()
+	cfg.Push.PodNamespace = "default"
+	cfg.Push.PodName = "target-sts-0"
+	// the audience is scoped to the namespace by default
+	if got := cfg.TokenReviewConfig().Audiences; !slices.Equal(got, []string{"local-session-tracker:default"}) {
+		t.Errorf("unexpected audiences: %v", got)
+	}
+	cfg.Auth.TokenReview.Audiences = []string{"custom"}
+	if got := cfg.TokenReviewConfig().Audiences; !slices.Equal(got, []string{"custom"}) {
+		t.Errorf("unexpected audiences: %v", got)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	cfg := Default()
+	if err := cfg.Load(filepath.Join("testdata", "config.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.BindFlags(fs)
+
+	env := map[string]string{
+		"LOCAL_SESSION_TRACKER_LOG_LEVEL":      "warn",
+		"LOCAL_SESSION_TRACKER_CONTAINERS":     "main,sidecar",
+		"LOCAL_SESSION_TRACKER_LISTEN_ADDRESS": ":1234",
+	}
+	err := ApplyEnv(fs, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Parse([]string{"--listen-address=:5678", "--session-ports=22,8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.ListenAddress != ":5678" {
+		t.Errorf("flag should take precedence: %s", cfg.ListenAddress)
+	}
+	if cfg.LogLevel != "warn" {
+		t.Errorf("environment variable should take precedence over file: %s", cfg.LogLevel)
+	}
+	if len(cfg.Filters.Containers) != 2 || cfg.Filters.Containers[1] != "sidecar" {
+		t.Errorf("unexpected containers: %v", cfg.Filters.Containers)
+	}
+	if len(cfg.Detection.SessionPorts) != 2 || cfg.Detection.SessionPorts[0] != 22 {
+		t.Errorf("unexpected session ports: %v", cfg.Detection.SessionPorts)
+	}
+	if cfg.Detection.InitTerminalIdleTimeout.Duration != 10*time.Minute {
+		t.Errorf("value in file should be kept: %s", cfg.Detection.InitTerminalIdleTimeout.Duration)
+	}
+
+	err = ApplyEnv(fs, func(key string) (string, bool) {
+		if key == "LOCAL_SESSION_TRACKER_SESSION_PORTS" {
+			return "ssh", true
+		}
+		return "", false
+	})
+	if err == nil || !strings.Contains(err.Error(), "LOCAL_SESSION_TRACKER_SESSION_PORTS") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
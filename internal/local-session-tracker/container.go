@@ -0,0 +1,107 @@
+package local_session_tracker
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+// containerIDRegexp matches the last component of the cgroup path of a container, such as
+// "<id>" (cgroupfs driver) or "cri-containerd-<id>.scope" (systemd driver).
+var containerIDRegexp = regexp.MustCompile(`([0-9a-f]{64})(?:\.scope)?$`)
+
+// containerNameRegexp matches the source of the termination log mounted by kubelet
+// (/var/lib/kubelet/pods/<pod UID>/containers/<container name>/<random>).
+var containerNameRegexp = regexp.MustCompile(`/pods/[^/]+/containers/([^/]+)/[^/]+$`)
+
+// containerInfo represents the container a process belongs to.
+type containerInfo struct {
+	// key identifies the container even if the ID cannot be found. It is empty if the cgroup is unknown.
+	key  string
+	id   string
+	name string
+}
+
+// containerResolver finds out the container a process belongs to.
+// The result is cached by the content of /proc/<pid>/cgroup, which is shared by the processes in a container.
+type containerResolver struct {
+	mu    sync.Mutex
+	cache map[string]containerInfo
+	seen  map[string]containerInfo
+}
+
+func newContainerResolver() *containerResolver {
+	return &containerResolver{
+		cache: make(map[string]containerInfo),
+		seen:  make(map[string]containerInfo),
+	}
+}
+
+// resolve returns the container the process belongs to.
+// The container ID is taken from the cgroup path, and the container name is taken from the termination log mount.
+// If the cgroup of the process cannot be read, an empty containerInfo is returned.
+func (r *containerResolver) resolve(fs procfs.FS, pid int) containerInfo {
+	cgroups, err := fs.Cgroups(pid)
+	if err != nil {
+		return containerInfo{}
+	}
+	paths := make([]string, 0, len(cgroups))
+	for _, cg := range cgroups {
+		paths = append(paths, cg.Path)
+	}
+	key := strings.Join(paths, "\n")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.seen[key]; ok {
+		return info
+	}
+	info, ok := r.cache[key]
+	if !ok {
+		info.key = key
+		info.id = containerIDFromCgroups(cgroups)
+		if mounts, err := fs.MountInfo(pid); err == nil {
+			info.name = containerNameFromMounts(mounts)
+		}
+	}
+	r.seen[key] = info
+	return info
+}
+
+// flush forgets the containers that were not seen since the last flush.
+func (r *containerResolver) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = r.seen
+	r.seen = make(map[string]containerInfo)
+}
+
+// containerIDFromCgroups returns the container ID in the cgroup paths.
+// The cgroup v2 path is preferred if available.
+// If the process is in the same cgroup namespace as the tracker, the path is "/" and no ID is found.
+func containerIDFromCgroups(cgroups []procfs.Cgroup) string {
+	id := ""
+	for _, cg := range cgroups {
+		m := containerIDRegexp.FindStringSubmatch(cg.Path)
+		if m == nil {
+			continue
+		}
+		id = m[1]
+		if cg.HierarchyID == 0 {
+			break
+		}
+	}
+	return id
+}
+
+// containerNameFromMounts returns the container name in the source of the termination log mount.
+func containerNameFromMounts(mounts []procfs.Mount) string {
+	for _, m := range mounts {
+		if match := containerNameRegexp.FindStringSubmatch(m.Root); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package local_session_tracker
+
+import (
+	"testing"
+
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+func TestContainerIDFromCgroups(t *testing.T) {
+	const id = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	testCases := []struct {
+		name    string
+		cgroups string
+		want    string
+	}{
+		{
+			name:    "cgroup v2 with systemd driver",
+			cgroups: "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e.slice/cri-containerd-" + id + ".scope\n",
+			want:    id,
+		},
+		{
+			name:    "cgroup v2 in a private cgroup namespace",
+			cgroups: "0::/../cri-containerd-" + id + ".scope\n",
+			want:    id,
+		},
+		{
+			name:    "cgroup v1 with cgroupfs driver",
+			cgroups: "12:pids:/kubepods/burstable/pod0f1e/" + id + "\n1:name=systemd:/kubepods/burstable/pod0f1e/" + id + "\n",
+			want:    id,
+		},
+		{
+			name:    "cgroup v1 with docker",
+			cgroups: "4:memory:/docker/" + id + "\n",
+			want:    id,
+		},
+		{
+			name:    "same cgroup namespace as the tracker",
+			cgroups: "0::/\n",
+			want:    "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cgroups, err := procfs.ParseCgroups(tc.cgroups)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := containerIDFromCgroups(cgroups); got != tc.want {
+				t.Errorf("unexpected container ID: %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package local_session_tracker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+// findEnvHolds returns the holds of the processes marked by the environment variable specified by HoldEnv.
+// The marker is inherited by the descendants, so only the topmost marked processes are reported.
+// The processes whose environment variables cannot be read are ignored.
+func (t *Tracker) findEnvHolds(stats map[int]*procfs.Stat, bootTime, now time.Time) ([]common.Hold, error) {
+	marked := make(map[int]string)
+	for pid := range stats {
+		env, err := t.fs.Environ(pid)
+		if err != nil {
+			continue
+		}
+		if marker, ok := t.matchHoldEnv(env); ok {
+			marked[pid] = marker
+		}
+	}
+
+	pids := make([]int, 0, len(marked))
+	for pid := range marked {
+		if _, ok := marked[stats[pid].PPID]; ok {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+
+	holds := make([]common.Hold, 0, len(pids))
+	for _, pid := range pids {
+		p, err := t.readProcess(stats[pid], bootTime, now)
+		if procfs.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			continue
+		}
+		holds = append(holds, common.Hold{
+			ID:        fmt.Sprintf("pid/%d", pid),
+			Reason:    marked[pid],
+			Owner:     p.User,
+			CreatedAt: p.StartTime,
+			Process:   &p.Process,
+		})
+	}
+	return holds, nil
+}
+
+// matchHoldEnv returns the marker variable in env if it matches HoldEnv.
+// HoldEnv is either "NAME=VALUE" that matches the exact value, or "NAME" that matches any non-empty value.
+func (t *Tracker) matchHoldEnv(env []string) (string, bool) {
+	name, value, exact := strings.Cut(t.config.HoldEnv, "=")
+	for _, kv := range env {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k != name {
+			continue
+		}
+		if exact && v != value || !exact && v == "" {
+			continue
+		}
+		return kv, true
+	}
+	return "", false
+}
@@ -0,0 +1,58 @@
+package local_session_tracker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// stuckScanTimeout is how long a scan may take before the tracker is considered stuck.
+const stuckScanTimeout = time.Minute
+
+// ErrPIDNamespaceNotShared is reported when the tracker cannot see any process of the other containers.
+var ErrPIDNamespaceNotShared = errors.New("no process outside the container of local-session-tracker is visible; " +
+	"the PID namespace is not shared, set shareProcessNamespace to true in the Pod spec")
+
+// Check scans the processes and returns an error if the tracker cannot detect sessions,
+// such as when /proc cannot be read or the PID namespace is not shared.
+func (t *Tracker) Check() error {
+	res, err := t.GetTTYStatus()
+	if err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+// Alive returns an error if a scan has been in progress for longer than stuckScanTimeout, which a restart may resolve.
+// Unlike Check, it does not scan the processes, so that a misconfiguration does not make the tracker restart in vain.
+func (t *Tracker) Alive() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.inflight; c != nil && time.Since(c.startedAt) > stuckScanTimeout {
+		return fmt.Errorf("the scan started at %s has not finished", c.startedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkPIDNamespace returns ErrPIDNamespaceNotShared if all the processes are in the same container as the tracker.
+// The containers are compared by the cgroups of the processes.
+// If the cgroup of the tracker cannot be read, it cannot be judged and nil is returned.
+func (t *Tracker) checkPIDNamespace(pids []int) error {
+	if t.selfPID == 0 {
+		return nil
+	}
+	self := t.containers.resolve(t.fs, t.selfPID)
+	if self.key == "" {
+		return nil
+	}
+	for _, pid := range pids {
+		c := t.containers.resolve(t.fs, pid)
+		if c.key != "" && c.key != self.key {
+			return nil
+		}
+	}
+	return ErrPIDNamespaceNotShared
+}
@@ -0,0 +1,201 @@
+// Package hold provides the storage of holds, that is the requests to keep the Pod protected without any session.
+package hold
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+// DefaultPath is the default path of the file to store holds.
+// The directory should be a volume shared among the containers in the Pod.
+const DefaultPath = "/var/run/login-protector/holds.json"
+
+// DefaultSocketPath is the default path of the Unix socket to serve the hold API to the other containers in the Pod.
+const DefaultSocketPath = "/var/run/login-protector/tracker.sock"
+
+// ErrNotFound is returned when the hold to be released does not exist.
+var ErrNotFound = errors.New("hold not found")
+
+// ErrNotOwner is returned when a user releases the hold of another user.
+var ErrNotOwner = errors.New("hold is owned by another user")
+
+// ErrNoReason is returned when a hold without reason is added.
+var ErrNoReason = errors.New("reason is required")
+
+// ErrBrokenFile is returned when the file cannot be parsed.
+var ErrBrokenFile = errors.New("broken hold file")
+
+// FileMode is the permission of the file and the lock file.
+// They are readable by the containers in the Pod through the group given by fsGroup of the Pod,
+// but writable only by local-session-tracker, so that the holds are modified only through the socket checking the owner.
+const FileMode = 0640
+
+// file represents the content of the file to store holds.
+type file struct {
+	Holds []common.Hold `json:"holds"`
+}
+
+// Store represents the file to store holds.
+// The file is replaced atomically, and the updates are serialized by an advisory lock on "<path>.lock".
+type Store struct {
+	path string
+}
+
+// NewStore returns a Store.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Path returns the path of the file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// List returns the holds that have not expired.
+// If the file does not exist, no holds are returned.
+// If the file is broken, an error wrapping ErrBrokenFile is returned.
+func (s *Store) List(now time.Time) ([]common.Hold, error) {
+	holds, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return active(holds, now), nil
+}
+
+// Add registers a hold, and returns it with the ID and the creation time.
+func (s *Store) Add(h common.Hold, now time.Time) (common.Hold, error) {
+	if h.Reason == "" {
+		return common.Hold{}, ErrNoReason
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return common.Hold{}, err
+	}
+	h.ID = hex.EncodeToString(id)
+	h.CreatedAt = now
+
+	err := s.update(now, func(holds []common.Hold) ([]common.Hold, error) {
+		return append(holds, h), nil
+	})
+	if err != nil {
+		return common.Hold{}, err
+	}
+	return h, nil
+}
+
+// Remove releases the hold.
+func (s *Store) Remove(id string, now time.Time) error {
+	return s.remove(id, "", now)
+}
+
+// RemoveOwned releases the hold only if it is owned by owner.
+func (s *Store) RemoveOwned(id, owner string, now time.Time) error {
+	return s.remove(id, owner, now)
+}
+
+// remove releases the hold. If owner is not empty, the hold of another owner is not released.
+func (s *Store) remove(id, owner string, now time.Time) error {
+	return s.update(now, func(holds []common.Hold) ([]common.Hold, error) {
+		for i, h := range holds {
+			if h.ID != id {
+				continue
+			}
+			if owner != "" && h.Owner != owner {
+				return nil, fmt.Errorf("%w: %s", ErrNotOwner, id)
+			}
+			return append(holds[:i], holds[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	})
+}
+
+// update modifies the holds under the lock. The expired holds are removed at the same time.
+func (s *Store) update(now time.Time, fn func([]common.Hold) ([]common.Hold, error)) error {
+	lock, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, FileMode)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	// the permission is narrowed by umask on creation, and only the owner can change it.
+	lock.Chmod(FileMode) //nolint:errcheck
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN) //nolint:errcheck
+
+	holds, err := s.read()
+	if err != nil {
+		return err
+	}
+	holds, err = fn(active(holds, now))
+	if err != nil {
+		return err
+	}
+	return s.write(holds)
+}
+
+func (s *Store) read() ([]common.Hold, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []common.Hold{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrBrokenFile, s.path, err)
+	}
+	if f.Holds == nil {
+		f.Holds = []common.Hold{}
+	}
+	return f.Holds, nil
+}
+
+// write replaces the file with a temporary file so that the readers without the lock never see a partial content.
+func (s *Store) write(holds []common.Hold) error {
+	data, err := json.Marshal(&file{Holds: holds})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the holds are registered by the users in other containers.
+	if err := tmp.Chmod(FileMode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// active returns the holds that have not expired.
+func active(holds []common.Hold, now time.Time) []common.Hold {
+	res := make([]common.Hold, 0, len(holds))
+	for _, h := range holds {
+		if h.ExpiresAt != nil && !now.Before(*h.ExpiresAt) {
+			continue
+		}
+		res = append(res, h)
+	}
+	return res
+}
@@ -0,0 +1,95 @@
+package hold
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+func TestStore(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "holds.json"))
+	now := time.Unix(1700000000, 0)
+
+	holds, err := store.List(now)
+	if err != nil {
+		t.Fatalf("failed to list holds of a missing file: %v", err)
+	}
+	if len(holds) != 0 {
+		t.Errorf("unexpected holds: %+v", holds)
+	}
+
+	if _, err := store.Add(common.Hold{Owner: "alice"}, now); !errors.Is(err, ErrNoReason) {
+		t.Errorf("expected ErrNoReason, but got %v", err)
+	}
+
+	expiresAt := now.Add(time.Hour)
+	h1, err := store.Add(common.Hold{Reason: "batch job", Owner: "alice", ExpiresAt: &expiresAt}, now)
+	if err != nil {
+		t.Fatalf("failed to add a hold: %v", err)
+	}
+	if h1.ID == "" || !h1.CreatedAt.Equal(now) {
+		t.Errorf("unexpected hold: %+v", h1)
+	}
+	h2, err := store.Add(common.Hold{Reason: "migration", Owner: "bob"}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to add a hold: %v", err)
+	}
+	if h1.ID == h2.ID {
+		t.Errorf("duplicate ID: %s", h1.ID)
+	}
+
+	holds, err = store.List(now.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatalf("failed to list holds: %v", err)
+	}
+	if len(holds) != 2 || holds[0].ID != h1.ID || holds[0].Reason != "batch job" || holds[1].ID != h2.ID {
+		t.Errorf("unexpected holds: %+v", holds)
+	}
+
+	// the hold of alice has expired
+	holds, err = store.List(now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to list holds: %v", err)
+	}
+	if len(holds) != 1 || holds[0].ID != h2.ID {
+		t.Errorf("unexpected holds: %+v", holds)
+	}
+
+	if err := store.Remove("unknown", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, but got %v", err)
+	}
+	if err := store.RemoveOwned(h2.ID, "alice", now); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner, but got %v", err)
+	}
+	if err := store.Remove(h2.ID, now); err != nil {
+		t.Fatalf("failed to remove a hold: %v", err)
+	}
+	holds, err = store.List(now)
+	if err != nil {
+		t.Fatalf("failed to list holds: %v", err)
+	}
+	if len(holds) != 1 || holds[0].ID != h1.ID {
+		t.Errorf("unexpected holds: %+v", holds)
+	}
+
+	for _, f := range []string{store.Path(), store.Path() + ".lock"} {
+		info, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != FileMode {
+			t.Errorf("unexpected permission of %s: %s", f, info.Mode().Perm())
+		}
+	}
+
+	if err := os.WriteFile(store.Path(), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.List(now); !errors.Is(err, ErrBrokenFile) {
+		t.Errorf("expected ErrBrokenFile, but got %v", err)
+	}
+}
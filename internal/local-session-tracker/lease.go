@@ -0,0 +1,141 @@
+package local_session_tracker
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// leaseSession represents a session described in the annotation of the Lease.
+type leaseSession struct {
+	Kind      string `json:"kind"`
+	User      string `json:"user"`
+	TTY       string `json:"tty,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+// leaseHold represents a hold described in the annotation of the Lease.
+type leaseHold struct {
+	Reason string `json:"reason"`
+	Owner  string `json:"owner"`
+}
+
+// renewLease renews the Lease named after the Pod if loggedIn is true, or releases it otherwise.
+// The holder identity of the Lease is the users of the sessions, and the sessions and the holds are described in its annotations.
+func (p *Pusher) renewLease(ctx context.Context, res *common.TTYStatus, loggedIn bool) error {
+	leases := p.client.CoordinationV1().Leases(p.config.PodNamespace)
+	lease, err := leases.Get(ctx, p.config.PodName, metav1.GetOptions{})
+	notFound := k8serrors.IsNotFound(err)
+	if err != nil && !notFound {
+		return err
+	}
+	if notFound {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: p.config.PodNamespace,
+				Name:      p.config.PodName,
+			},
+		}
+	}
+	if lease.Labels == nil {
+		lease.Labels = make(map[string]string)
+	}
+	lease.Labels[common.LabelKeyLoginLease] = common.ValueTrue
+	// The Lease left by the previous Pod of the same name is taken over by the current Pod.
+	if p.config.PodUID != "" {
+		lease.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       common.KindPod,
+			Name:       p.config.PodName,
+			UID:        types.UID(p.config.PodUID),
+		}}
+	}
+
+	sessions := res.ActiveSessions(p.config.IdleTimeout, p.config.ProtectDetachedSessions)
+	if err := setLeaseAnnotations(lease, sessions, res.Holds); err != nil {
+		return err
+	}
+
+	now := metav1.NewMicroTime(time.Now())
+	if loggedIn {
+		holder := leaseHolder(sessions, res.Holds, p.config.PodName)
+		if lease.Spec.HolderIdentity == nil {
+			transitions := int32(0)
+			if lease.Spec.LeaseTransitions != nil {
+				transitions = *lease.Spec.LeaseTransitions + 1
+			}
+			lease.Spec.LeaseTransitions = &transitions
+			lease.Spec.AcquireTime = &now
+		}
+		duration := int32(p.config.LeaseDuration.Seconds())
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.LeaseDurationSeconds = &duration
+	} else {
+		lease.Spec.HolderIdentity = nil
+		lease.Spec.AcquireTime = nil
+	}
+	lease.Spec.RenewTime = &now
+
+	if notFound {
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	} else {
+		_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	return err
+}
+
+// setLeaseAnnotations describes the sessions and the holds in the annotations of the Lease.
+func setLeaseAnnotations(lease *coordinationv1.Lease, sessions []common.Session, holds []common.Hold) error {
+	ls := make([]leaseSession, len(sessions))
+	for i, s := range sessions {
+		ls[i] = leaseSession{Kind: s.Kind, User: s.User, TTY: s.TTY, Container: s.Container}
+	}
+	lh := make([]leaseHold, len(holds))
+	for i, h := range holds {
+		lh[i] = leaseHold{Reason: h.Reason, Owner: h.Owner}
+	}
+	sessionsJSON, err := json.Marshal(ls)
+	if err != nil {
+		return err
+	}
+	holdsJSON, err := json.Marshal(lh)
+	if err != nil {
+		return err
+	}
+
+	if lease.Annotations == nil {
+		lease.Annotations = make(map[string]string)
+	}
+	lease.Annotations[common.AnnotationKeyLeaseSessions] = string(sessionsJSON)
+	lease.Annotations[common.AnnotationKeyLeaseHolds] = string(holdsJSON)
+	return nil
+}
+
+// leaseHolder returns the comma-separated users of the sessions and the owners of the holds.
+// If no user is known, the Pod name is returned instead.
+func leaseHolder(sessions []common.Session, holds []common.Hold, podName string) string {
+	users := make([]string, 0, len(sessions)+len(holds))
+	for _, s := range sessions {
+		if s.User != "" {
+			users = append(users, s.User)
+		}
+	}
+	for _, h := range holds {
+		if h.Owner != "" {
+			users = append(users, h.Owner)
+		}
+	}
+	if len(users) == 0 {
+		return podName
+	}
+	slices.Sort(users)
+	return strings.Join(slices.Compact(users), ",")
+}
@@ -3,26 +3,95 @@ package local_session_tracker
 import (
 	"math"
 
+	"github.com/cybozu-go/login-protector/internal/common"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
 const metricsNamespace = "local_session_tracker"
 
-func InitMetrics(logger *zap.Logger) {
-	prometheus.MustRegister(prometheus.NewGaugeFunc(
-		prometheus.GaugeOpts{
-			Namespace: metricsNamespace,
-			Name:      "ttys",
-			Help:      "Number of controlling terminals observed",
-		},
-		func() float64 {
-			res, err := getTTYStatus()
-			if err != nil {
-				logger.Error("failed to count ttys", zap.Error(err))
-				return math.NaN()
-			}
-			return float64(res.Total)
+var pushErrorsCounter = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "push_errors_total",
+		Help:      "Number of errors occurred in updating the login status of the Pod in push mode",
+	},
+)
+
+func InitMetrics(logger *zap.Logger, tracker *Tracker) {
+	prometheus.MustRegister(newStatusCollector(logger, tracker))
+	prometheus.MustRegister(pushErrorsCounter)
+}
+
+// statusGauge represents a gauge whose value is calculated from the TTY status.
+type statusGauge struct {
+	desc *prometheus.Desc
+	fn   func(*common.TTYStatus) float64
+}
+
+// statusCollector collects the gauges calculated from the TTY status.
+// The status is read once per scrape and shared by all the gauges.
+type statusCollector struct {
+	logger  *zap.Logger
+	tracker *Tracker
+	gauges  []statusGauge
+}
+
+func newStatusCollector(logger *zap.Logger, tracker *Tracker) *statusCollector {
+	gauge := func(name, help string, fn func(*common.TTYStatus) float64) statusGauge {
+		return statusGauge{
+			desc: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, nil, nil),
+			fn:   fn,
+		}
+	}
+	return &statusCollector{
+		logger:  logger,
+		tracker: tracker,
+		gauges: []statusGauge{
+			gauge("ttys", "Number of sessions observed, not including holds",
+				func(res *common.TTYStatus) float64 {
+					return float64(len(res.Sessions))
+				},
+			),
+			gauge("holds", "Number of active holds",
+				func(res *common.TTYStatus) float64 {
+					return float64(len(res.Holds))
+				},
+			),
+			gauge("oldest_session_start_time_seconds", "Start time of the oldest session associated with TTY since unix epoch in seconds",
+				func(res *common.TTYStatus) float64 {
+					if res.OldestStartTime == nil {
+						return 0
+					}
+					return float64(res.OldestStartTime.Unix())
+				},
+			),
+			gauge("oldest_session_age_seconds", "How long the oldest session associated with TTY has been running in seconds",
+				func(res *common.TTYStatus) float64 {
+					return float64(res.OldestAgeSeconds)
+				},
+			),
 		},
-	))
+	}
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, g := range c.gauges {
+		ch <- g.desc
+	}
+}
+
+// Collect reads the status once and reports all the gauges. The gauges are NaN if the status cannot be read.
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	res, err := c.tracker.GetTTYStatus()
+	if err != nil {
+		c.logger.Error("failed to count ttys", zap.Error(err))
+	}
+	for _, g := range c.gauges {
+		value := math.NaN()
+		if err == nil {
+			value = g.fn(res)
+		}
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, value)
+	}
 }
@@ -0,0 +1,46 @@
+package local_session_tracker
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+func TestStatusCollector(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true, HoldFile: filepath.Join(t.TempDir(), "holds.json")})
+	// the hold is reported only by the holds gauge
+	if _, err := tracker.HoldStore().Add(common.Hold{Reason: "batch job", Owner: "alice"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(newStatusCollector(zap.NewNop(), tracker))
+
+	expected := `
+# HELP local_session_tracker_holds Number of active holds
+# TYPE local_session_tracker_holds gauge
+local_session_tracker_holds 1
+# HELP local_session_tracker_oldest_session_age_seconds How long the oldest session associated with TTY has been running in seconds
+# TYPE local_session_tracker_oldest_session_age_seconds gauge
+local_session_tracker_oldest_session_age_seconds 1000
+# HELP local_session_tracker_ttys Number of sessions observed, not including holds
+# TYPE local_session_tracker_ttys gauge
+local_session_tracker_ttys 1
+`
+	err = testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"local_session_tracker_holds", "local_session_tracker_oldest_session_age_seconds", "local_session_tracker_ttys")
+	if err != nil {
+		t.Error(err)
+	}
+}
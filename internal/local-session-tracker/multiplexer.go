@@ -0,0 +1,140 @@
+package local_session_tracker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+// The names of the terminal multiplexers.
+const (
+	multiplexerTmux   = "tmux"
+	multiplexerScreen = "screen"
+)
+
+// screenDirs is the list of the directories where screen creates the sockets, relative to the root directory.
+// The sockets are created in the per-user subdirectories "S-<user>".
+var screenDirs = []string{"run/screen", "var/run/screen", "tmp/screens", "tmp/uscreens"}
+
+// multiplexer represents the server process of a terminal multiplexer.
+type multiplexer struct {
+	name string
+	// socket is the path of the socket seen from the server. It is empty if not found.
+	socket string
+	// attached means that a client is attached to the server.
+	attached bool
+}
+
+// findMultiplexers finds the server processes of tmux and screen and returns the map from their PIDs to the multiplexers.
+func (t *Tracker) findMultiplexers(stats map[int]*procfs.Stat) map[int]multiplexer {
+	muxes := make(map[int]multiplexer)
+	for pid, st := range stats {
+		var name string
+		switch st.Comm {
+		case "tmux: server":
+			name = multiplexerTmux
+		case "screen":
+			// The server process of screen renames itself "SCREEN" while the clients do not.
+			args, err := t.fs.Cmdline(pid)
+			if err != nil || len(args) == 0 || args[0] != "SCREEN" {
+				continue
+			}
+			name = multiplexerScreen
+		default:
+			continue
+		}
+
+		m := multiplexer{
+			name:   name,
+			socket: t.multiplexerSocket(pid, name),
+		}
+		// Both tmux and screen set the executable bit of the socket while a client is attached.
+		// If the socket is not found, the server is considered as detached so as not to miss the session.
+		if m.socket != "" {
+			if info, err := os.Stat(t.fs.RootPath(pid, m.socket)); err == nil {
+				m.attached = info.Mode()&0o100 != 0
+			}
+		}
+		muxes[pid] = m
+	}
+	return muxes
+}
+
+// multiplexerSocket returns the path of the socket of the multiplexer server.
+func (t *Tracker) multiplexerSocket(pid int, name string) string {
+	inodes, err := t.fs.SocketInodes(pid)
+	if err == nil {
+		sockets, err := t.fs.UnixSockets(pid)
+		if err == nil {
+			for _, s := range sockets {
+				if s.Listening && strings.HasPrefix(s.Path, "/") && slices.Contains(inodes, s.Inode) {
+					return s.Path
+				}
+			}
+		}
+	}
+	if name != multiplexerScreen {
+		return ""
+	}
+
+	// screen may be built to use a named pipe instead of a socket, whose name is "<pid>.<session name>".
+	root := t.fs.RootPath(pid)
+	for _, dir := range screenDirs {
+		matches, err := filepath.Glob(filepath.Join(root, dir, "S-*", fmt.Sprintf("%d.*", pid)))
+		if err != nil || len(matches) == 0 {
+			continue
+		}
+		return strings.TrimPrefix(matches[0], root)
+	}
+	return ""
+}
+
+// foldDetachedSessions moves the processes under the detached multiplexer servers into the sessions of the servers.
+// The processes in the windows of a multiplexer have their own terminals, but they are no longer used by anyone
+// once the multiplexer is detached.
+func (t *Tracker) foldDetachedSessions(procs []*sessionProcess, muxes map[int]multiplexer, stats map[int]*procfs.Stat) {
+	for _, p := range procs {
+		if p.kind != common.SessionKindTTY {
+			continue
+		}
+		for pid := p.ppid; pid > 0; {
+			if m, ok := muxes[pid]; ok {
+				if !m.attached {
+					p.sessionID = pid
+					p.kind = common.SessionKindDetached
+				}
+				break
+			}
+			st, ok := stats[pid]
+			if !ok {
+				break
+			}
+			pid = st.PPID
+		}
+	}
+}
+
+// attachMultiplexers sets the multiplexers of the detached sessions.
+func (t *Tracker) attachMultiplexers(sessions []common.Session, muxes map[int]multiplexer) {
+	for i := range sessions {
+		if sessions[i].Kind != common.SessionKindDetached {
+			continue
+		}
+		pid, err := strconv.Atoi(sessions[i].ID)
+		if err != nil {
+			continue
+		}
+		if m, ok := muxes[pid]; ok {
+			sessions[i].Multiplexer = &common.Multiplexer{
+				Name:   m.name,
+				Socket: m.socket,
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package local_session_tracker
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+// readNetworkSessions returns the sessions of the established TCP connections to the ports in SessionPorts,
+// such as the connections from the browsers to web terminals and IDEs.
+// The connections are read from /proc/net/tcp and /proc/net/tcp6 in the network namespace of the Pod.
+// The leader of a session is the process owning the socket of the connection if it can be found.
+func (t *Tracker) readNetworkSessions(stats map[int]*procfs.Stat, bootTime, now time.Time) ([]common.Session, error) {
+	sockets, err := t.fs.NetTCPSockets()
+	if err != nil {
+		return nil, err
+	}
+	conns := make([]procfs.TCPSocket, 0)
+	for _, s := range sockets {
+		if s.State == procfs.TCPEstablished && slices.Contains(t.config.SessionPorts, s.LocalPort) {
+			conns = append(conns, s)
+		}
+	}
+	if len(conns) == 0 {
+		return []common.Session{}, nil
+	}
+
+	owners, err := t.findSocketOwners(stats, bootTime, now)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]common.Session, 0, len(conns))
+	keys := make([]string, 0, len(conns))
+	cpuTicks := make(map[string]uint64, len(conns))
+	for _, c := range conns {
+		s := common.Session{
+			ID:        fmt.Sprintf("%d/%s", c.LocalPort, net.JoinHostPort(c.RemoteIP.String(), strconv.Itoa(c.RemotePort))),
+			Kind:      common.SessionKindNetwork,
+			Processes: make([]common.Process, 0),
+			Connection: &common.Connection{
+				LocalPort:  c.LocalPort,
+				RemoteIP:   c.RemoteIP.String(),
+				RemotePort: c.RemotePort,
+			},
+		}
+		key := fmt.Sprintf("%s/%s", common.SessionKindNetwork, s.ID)
+		if owner, ok := owners[c.Inode]; ok {
+			if owner == nil {
+				// the owner is not in the target containers.
+				continue
+			}
+			s.Leader = owner.Process
+			s.User = owner.User
+			s.Container = owner.Container
+			s.Processes = append(s.Processes, owner.Process)
+			cpuTicks[key] = owner.cpuTicks
+		} else {
+			cpuTicks[key] = 0
+		}
+		sessions = append(sessions, s)
+		keys = append(keys, key)
+	}
+
+	// The time the connection was established is not available, so the time it was observed for the first time is used instead.
+	samples := t.recorder.update(cpuTicks, now)
+	for i := range sessions {
+		s := &sessions[i]
+		sample := samples[keys[i]]
+		s.StartTime = sample.firstSeenAt
+		s.IdleSeconds = int64(now.Sub(sample.changedAt).Seconds())
+		s.AgeSeconds = int64(now.Sub(s.StartTime).Seconds())
+	}
+	return sessions, nil
+}
+
+// findSocketOwners returns the map from the inode numbers of the sockets to the processes owning them.
+// The value is nil if the owner is not in the target containers.
+// The sockets of the processes whose file descriptors cannot be read are not in the map.
+func (t *Tracker) findSocketOwners(stats map[int]*procfs.Stat, bootTime, now time.Time) (map[uint64]*sessionProcess, error) {
+	pids := make([]int, 0, len(stats))
+	for pid := range stats {
+		pids = append(pids, pid)
+	}
+	slices.Sort(pids)
+
+	owners := make(map[uint64]*sessionProcess)
+	for _, pid := range pids {
+		inodes, err := t.fs.SocketInodes(pid)
+		if err != nil || len(inodes) == 0 {
+			continue
+		}
+		p, err := t.readProcess(stats[pid], bootTime, now)
+		if procfs.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, inode := range inodes {
+			// the socket may be inherited by the child processes, so the one with the lowest PID is considered as the owner.
+			if _, ok := owners[inode]; !ok {
+				owners[inode] = p
+			}
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,61 @@
+package procfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrBrokenCgroup is returned when /proc/<pid>/cgroup cannot be parsed.
+var ErrBrokenCgroup = errors.New("broken process cgroup")
+
+// Cgroup represents a line of /proc/<pid>/cgroup.
+type Cgroup struct {
+	// HierarchyID is the ID of the cgroup hierarchy. It is 0 for cgroup v2.
+	HierarchyID int
+	// Controllers is the list of controllers bound to the hierarchy. It is empty for cgroup v2.
+	Controllers []string
+	// Path is the path of the cgroup relative to the root of the cgroup namespace of the reader.
+	Path string
+}
+
+// Cgroups reads /proc/<pid>/cgroup.
+func (f FS) Cgroups(pid int) ([]Cgroup, error) {
+	data, err := os.ReadFile(f.Path(strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseCgroups(string(data))
+}
+
+// ParseCgroups parses the content of /proc/<pid>/cgroup.
+// Each line is "hierarchy-ID:controller-list:cgroup-path".
+func ParseCgroups(data string) ([]Cgroup, error) {
+	res := make([]Cgroup, 0)
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		// The path may contain ':', so split the line into 3 parts at most.
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenCgroup, line)
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenCgroup, line)
+		}
+		cg := Cgroup{
+			HierarchyID: id,
+			Controllers: make([]string, 0),
+			Path:        fields[2],
+		}
+		if fields[1] != "" {
+			cg.Controllers = strings.Split(fields[1], ",")
+		}
+		res = append(res, cg)
+	}
+	return res, nil
+}
@@ -0,0 +1,65 @@
+package procfs
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseCgroups(t *testing.T) {
+	v1 := "12:pids:/kubepods/burstable/pod0f1e2d3c/0123abcd\n" +
+		"4:cpu,cpuacct:/kubepods/burstable/pod0f1e2d3c/0123abcd\n" +
+		"1:name=systemd:/kubepods/burstable/pod0f1e2d3c/0123abcd\n" +
+		"0::/\n"
+	cgroups, err := ParseCgroups(v1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cgroups) != 4 {
+		t.Fatalf("unexpected cgroups: %+v", cgroups)
+	}
+	if cgroups[1].HierarchyID != 4 || !slices.Equal(cgroups[1].Controllers, []string{"cpu", "cpuacct"}) ||
+		cgroups[1].Path != "/kubepods/burstable/pod0f1e2d3c/0123abcd" {
+		t.Errorf("unexpected cgroup: %+v", cgroups[1])
+	}
+	if cgroups[3].HierarchyID != 0 || len(cgroups[3].Controllers) != 0 || cgroups[3].Path != "/" {
+		t.Errorf("unexpected cgroup: %+v", cgroups[3])
+	}
+
+	// the path may contain ':'
+	cgroups, err = ParseCgroups("0::/system.slice/a:b.scope\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cgroups[0].Path != "/system.slice/a:b.scope" {
+		t.Errorf("unexpected cgroup: %+v", cgroups[0])
+	}
+
+	_, err = ParseCgroups("broken\n")
+	if !errors.Is(err, ErrBrokenCgroup) {
+		t.Errorf("expected ErrBrokenCgroup, but got %v", err)
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	data := "1201 1100 0:120 / / rw,relatime master:1 - overlay overlay rw,lowerdir=/var/lib/x\n" +
+		"1211 1201 253:1 /var/lib/kubelet/pods/0f1e/containers/main/5e4f /dev/termination-log rw,relatime - ext4 /dev/vda1 rw\n" +
+		"1212 1201 253:1 /data/with\\040space /mnt/data rw shared:2 master:3 - ext4 /dev/vdb rw\n"
+	mounts, err := ParseMountInfo(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Mount{
+		{Root: "/", MountPoint: "/", FSType: "overlay", Source: "overlay"},
+		{Root: "/var/lib/kubelet/pods/0f1e/containers/main/5e4f", MountPoint: "/dev/termination-log", FSType: "ext4", Source: "/dev/vda1"},
+		{Root: "/data/with space", MountPoint: "/mnt/data", FSType: "ext4", Source: "/dev/vdb"},
+	}
+	if !slices.Equal(mounts, want) {
+		t.Errorf("unexpected mounts:\n got: %+v\nwant: %+v", mounts, want)
+	}
+
+	_, err = ParseMountInfo("1201 1100 0:120 / / rw\n")
+	if !errors.Is(err, ErrBrokenMountInfo) {
+		t.Errorf("expected ErrBrokenMountInfo, but got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+// Package procfs provides functions to read process information from the Linux procfs.
+package procfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultRoot is the default mount point of procfs.
+const DefaultRoot = "/proc"
+
+var errBootTime = errors.New("boot time not found")
+var errUptime = errors.New("uptime not found")
+
+// ErrBrokenStatus is returned when /proc/<pid>/status cannot be parsed.
+var ErrBrokenStatus = errors.New("broken process status")
+
+// FS represents a procfs mounted at a directory.
+type FS struct {
+	root string
+}
+
+// NewFS returns a procfs mounted at root.
+func NewFS(root string) FS {
+	return FS{root: root}
+}
+
+// Root returns the mount point of the procfs.
+func (f FS) Root() string {
+	return f.root
+}
+
+// Path returns the path of a file in the procfs.
+func (f FS) Path(elem ...string) string {
+	return filepath.Join(append([]string{f.root}, elem...)...)
+}
+
+// PIDs returns the IDs of all processes in the procfs.
+func (f FS) PIDs() ([]int, error) {
+	dirs, err := os.ReadDir(f.root)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(dirs))
+	for _, d := range dirs {
+		// if the name contains non-digit characters, it is not a process directory.
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || pid <= 0 {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// UID returns the effective user ID of the owner of the process.
+func (f FS) UID(pid int) (uint32, error) {
+	statusBytes, err := os.ReadFile(f.Path(strconv.Itoa(pid), "status"))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(statusBytes), "\n") {
+		fields := strings.Fields(line)
+		// The line is "Uid: <real> <effective> <saved set> <filesystem>".
+		if len(fields) < 3 || fields[0] != "Uid:" {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid uid %q", ErrBrokenStatus, fields[2])
+		}
+		return uint32(uid), nil
+	}
+	return 0, fmt.Errorf("%w: uid not found", ErrBrokenStatus)
+}
+
+// Cmdline returns the command line arguments of the process.
+// A process may overwrite its arguments to show its status, such as "sshd: alice@pts/0".
+// The trailing empty arguments left by such a process are removed.
+func (f FS) Cmdline(pid int) ([]string, error) {
+	data, err := os.ReadFile(f.Path(strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	args := strings.Split(string(data), "\x00")
+	for len(args) > 0 && args[len(args)-1] == "" {
+		args = args[:len(args)-1]
+	}
+	return args, nil
+}
+
+// Environ returns the environment variables of the process in the form "key=value".
+// They are the ones given when the process started, and do not reflect the changes made by the process itself.
+// Reading them requires the permission to ptrace the process.
+func (f FS) Environ(pid int) ([]string, error) {
+	data, err := os.ReadFile(f.Path(strconv.Itoa(pid), "environ"))
+	if err != nil {
+		return nil, err
+	}
+	env := strings.Split(string(data), "\x00")
+	if len(env) > 0 && env[len(env)-1] == "" {
+		env = env[:len(env)-1]
+	}
+	return env, nil
+}
+
+// MountNamespace returns the identifier of the mount namespace of the process such as "mnt:[4026531840]".
+func (f FS) MountNamespace(pid int) (string, error) {
+	return os.Readlink(f.Path(strconv.Itoa(pid), "ns", "mnt"))
+}
+
+// RootPath returns the path of a file seen from the root directory of the process.
+// Accessing it requires the permission to ptrace the process.
+func (f FS) RootPath(pid int, elem ...string) string {
+	return f.Path(append([]string{strconv.Itoa(pid), "root"}, elem...)...)
+}
+
+// BootTime returns the time the system booted.
+func (f FS) BootTime() (time.Time, error) {
+	statBytes, err := os.ReadFile(f.Path("stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(statBytes), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		btime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(btime, 0), nil
+	}
+	return time.Time{}, errBootTime
+}
+
+// Uptime returns how long the system has been running.
+func (f FS) Uptime() (time.Duration, error) {
+	uptimeBytes, err := os.ReadFile(f.Path("uptime"))
+	if err != nil {
+		return 0, err
+	}
+	// The file contains the uptime and the idle time in seconds.
+	fields := strings.Fields(string(uptimeBytes))
+	if len(fields) == 0 {
+		return 0, errUptime
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(uptime * float64(time.Second)), nil
+}
+
+// IsNotExist returns true if the error means that the process has already exited.
+// A process may exit at any moment while its files are being read, so the caller
+// should skip the process rather than failing.
+func IsNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,71 @@
+package procfs
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFSPIDs(t *testing.T) {
+	pids, err := NewFS("testdata/proc").PIDs()
+	if err != nil {
+		t.Fatalf("failed to list pids: %v", err)
+	}
+	if !slices.Equal(pids, []int{1, 100, 101}) {
+		t.Errorf("unexpected pids: %v", pids)
+	}
+}
+
+func TestFSBootTime(t *testing.T) {
+	bootTime, err := NewFS("testdata/proc").BootTime()
+	if err != nil {
+		t.Fatalf("failed to get boot time: %v", err)
+	}
+	if !bootTime.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected boot time: %v", bootTime)
+	}
+}
+
+func TestFSUID(t *testing.T) {
+	uid, err := NewFS("testdata/proc").UID(100)
+	if err != nil {
+		t.Fatalf("failed to get uid: %v", err)
+	}
+	// the effective UID, the second field of the Uid line
+	if uid != 1001 {
+		t.Errorf("unexpected uid: %d", uid)
+	}
+}
+
+func TestFSCmdline(t *testing.T) {
+	fs := NewFS("testdata/proc")
+	testCases := []struct {
+		name string
+		pid  int
+		want []string
+	}{
+		{name: "arguments", pid: 100, want: []string{"bash", "-l"}},
+		{name: "process title padded with NUL", pid: 101, want: []string{"sshd: alice@notty"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := fs.Cmdline(tc.pid)
+			if err != nil {
+				t.Fatalf("failed to read cmdline: %v", err)
+			}
+			if !slices.Equal(args, tc.want) {
+				t.Errorf("unexpected cmdline: %q", args)
+			}
+		})
+	}
+}
+
+func TestFSEnviron(t *testing.T) {
+	env, err := NewFS("testdata/proc").Environ(100)
+	if err != nil {
+		t.Fatalf("failed to read environ: %v", err)
+	}
+	if !slices.Equal(env, []string{"PATH=/usr/bin:/bin", "LOGIN_PROTECTOR_HOLD=1"}) {
+		t.Errorf("unexpected environ: %q", env)
+	}
+}
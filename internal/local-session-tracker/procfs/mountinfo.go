@@ -0,0 +1,83 @@
+package procfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrBrokenMountInfo is returned when /proc/<pid>/mountinfo cannot be parsed.
+var ErrBrokenMountInfo = errors.New("broken process mountinfo")
+
+// Mount represents a line of /proc/<pid>/mountinfo.
+// Only the fields used by the tracker are provided.
+type Mount struct {
+	// Root is the pathname of the directory in the filesystem which forms the root of this mount.
+	Root string
+	// MountPoint is the pathname of the mount point relative to the root directory of the process.
+	MountPoint string
+	// FSType is the filesystem type.
+	FSType string
+	// Source is the filesystem-specific information such as the device.
+	Source string
+}
+
+// MountInfo reads /proc/<pid>/mountinfo.
+func (f FS) MountInfo(pid int) ([]Mount, error) {
+	data, err := os.ReadFile(f.Path(strconv.Itoa(pid), "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseMountInfo(string(data))
+}
+
+// ParseMountInfo parses the content of /proc/<pid>/mountinfo.
+// See proc(5) for the format.
+func ParseMountInfo(data string) ([]Mount, error) {
+	res := make([]Mount, 0)
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		// The optional fields are terminated by a single hyphen.
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 0 || len(fields) < sep+3 {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenMountInfo, line)
+		}
+		res = append(res, Mount{
+			Root:       unescapeMountPath(fields[3]),
+			MountPoint: unescapeMountPath(fields[4]),
+			FSType:     fields[sep+1],
+			Source:     unescapeMountPath(fields[sep+2]),
+		})
+	}
+	return res, nil
+}
+
+// unescapeMountPath decodes the octal escapes such as "\040" used for spaces in the paths.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,223 @@
+package procfs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrBrokenNetTCP is returned when /proc/<pid>/net/tcp cannot be parsed.
+var ErrBrokenNetTCP = errors.New("broken net tcp")
+
+// ErrBrokenNetUnix is returned when /proc/<pid>/net/unix cannot be parsed.
+var ErrBrokenNetUnix = errors.New("broken net unix")
+
+// The states of TCP sockets. See include/net/tcp_states.h of Linux.
+const (
+	TCPEstablished = 1
+	TCPListen      = 10
+)
+
+// TCPSocket represents a line of /proc/<pid>/net/tcp or /proc/<pid>/net/tcp6.
+// Only the fields used by the tracker are provided.
+type TCPSocket struct {
+	// LocalIP is the local IP address. It is an IPv6 address for the sockets in tcp6.
+	LocalIP net.IP
+	// LocalPort is the local port number.
+	LocalPort int
+	// RemoteIP is the remote IP address. It is unspecified for a listening socket.
+	RemoteIP net.IP
+	// RemotePort is the remote port number.
+	RemotePort int
+	// State is the state of the socket such as TCPEstablished.
+	State int
+	// UID is the effective user ID of the owner of the socket.
+	UID uint32
+	// Inode is the inode number of the socket, which appears in the link of /proc/<pid>/fd/<fd> as "socket:[<inode>]".
+	Inode uint64
+}
+
+// TCPSockets reads /proc/<pid>/net/tcp and /proc/<pid>/net/tcp6, which list the TCP sockets in the network namespace of the process.
+// If IPv6 is disabled, tcp6 does not exist and only IPv4 sockets are returned.
+func (f FS) TCPSockets(pid int) ([]TCPSocket, error) {
+	return f.readTCPSockets(strconv.Itoa(pid), "net")
+}
+
+// NetTCPSockets reads /proc/net/tcp and /proc/net/tcp6, which list the TCP sockets in the network namespace of the reader.
+func (f FS) NetTCPSockets() ([]TCPSocket, error) {
+	return f.readTCPSockets("net")
+}
+
+func (f FS) readTCPSockets(dir ...string) ([]TCPSocket, error) {
+	res := make([]TCPSocket, 0)
+	for _, name := range []string{"tcp", "tcp6"} {
+		data, err := os.ReadFile(f.Path(append(dir, name)...))
+		if name == "tcp6" && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sockets, err := ParseTCPSockets(string(data))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sockets...)
+	}
+	return res, nil
+}
+
+// SocketInodes returns the inode numbers of the sockets opened by the process.
+func (f FS) SocketInodes(pid int) ([]uint64, error) {
+	entries, err := os.ReadDir(f.Path(strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]uint64, 0)
+	for _, e := range entries {
+		link, err := os.Readlink(f.Path(strconv.Itoa(pid), "fd", e.Name()))
+		if err != nil {
+			// the fd may be closed while reading the directory.
+			continue
+		}
+		s, ok := strings.CutPrefix(link, "socket:[")
+		if !ok {
+			continue
+		}
+		inode, err := strconv.ParseUint(strings.TrimSuffix(s, "]"), 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, inode)
+	}
+	return res, nil
+}
+
+// UnixSocket represents a line of /proc/<pid>/net/unix.
+// Only the fields used by the tracker are provided.
+type UnixSocket struct {
+	// Listening means that the socket is accepting connections.
+	Listening bool
+	// Inode is the inode number of the socket.
+	Inode uint64
+	// Path is the path the socket is bound to. It is empty for an unbound socket and starts with "@" for an abstract socket.
+	Path string
+}
+
+// unixAcceptCon is the flag of a listening socket (__SO_ACCEPTCON).
+const unixAcceptCon = 0x10000
+
+// UnixSockets reads /proc/<pid>/net/unix, which lists the UNIX domain sockets in the network namespace of the process.
+func (f FS) UnixSockets(pid int) ([]UnixSocket, error) {
+	data, err := os.ReadFile(f.Path(strconv.Itoa(pid), "net", "unix"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseUnixSockets(string(data))
+}
+
+// ParseUnixSockets parses the content of /proc/<pid>/net/unix.
+// The first line is the header. Each of the other lines is "Num RefCount Protocol Flags Type St Inode [Path]".
+func ParseUnixSockets(data string) ([]UnixSocket, error) {
+	res := make([]UnixSocket, 0)
+	lines := strings.Split(data, "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetUnix, line)
+		}
+		flags, err := strconv.ParseUint(fields[3], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetUnix, line)
+		}
+		inode, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetUnix, line)
+		}
+		res = append(res, UnixSocket{
+			Listening: flags&unixAcceptCon != 0,
+			Inode:     inode,
+			Path:      strings.Join(fields[7:], " "),
+		})
+	}
+	return res, nil
+}
+
+// ParseTCPSockets parses the content of /proc/<pid>/net/tcp or /proc/<pid>/net/tcp6.
+// The first line is the header. Each of the other lines is
+// "sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...".
+func ParseTCPSockets(data string) ([]TCPSocket, error) {
+	res := make([]TCPSocket, 0)
+	lines := strings.Split(data, "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetTCP, line)
+		}
+		localIP, localPort, err := parseSocketAddr(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetTCP, line)
+		}
+		remoteIP, remotePort, err := parseSocketAddr(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetTCP, line)
+		}
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetTCP, line)
+		}
+		uid, err := strconv.ParseUint(fields[7], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetTCP, line)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrBrokenNetTCP, line)
+		}
+		res = append(res, TCPSocket{
+			LocalIP:    localIP,
+			LocalPort:  localPort,
+			RemoteIP:   remoteIP,
+			RemotePort: remotePort,
+			State:      int(state),
+			UID:        uint32(uid),
+			Inode:      inode,
+		})
+	}
+	return res, nil
+}
+
+// parseSocketAddr parses an address such as "0100007F:0016".
+// The IP address is printed as 32-bit words in the host byte order, which is assumed to be little endian,
+// and the port is printed in the network byte order.
+func parseSocketAddr(s string) (net.IP, int, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, errors.New("no port")
+	}
+	b, err := hex.DecodeString(ipHex)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return nil, 0, fmt.Errorf("invalid address length %d", len(b))
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	return net.IP(b), int(port), nil
+}
@@ -0,0 +1,176 @@
+package procfs
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseTCPSockets(t *testing.T) {
+	const header = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	testCases := []struct {
+		name    string
+		data    string
+		want    []TCPSocket
+		wantErr bool
+	}{
+		{
+			name: "ipv4",
+			data: header +
+				"   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 4000 1 0000000000000000 100 0 0 10 0\n" +
+				"   1: 0500000A:0016 0A0200C0:C350 01 00000000:00000000 02:000A7B8E 00000000  1000        0 5001 4 0000000000000000 20 4 31 10 -1\n",
+			want: []TCPSocket{
+				{LocalIP: net.IPv4zero, LocalPort: 22, RemoteIP: net.IPv4zero, State: TCPListen, Inode: 4000},
+				{
+					LocalIP: net.ParseIP("10.0.0.5"), LocalPort: 22, RemoteIP: net.ParseIP("192.0.2.10"), RemotePort: 50000,
+					State: TCPEstablished, UID: 1000, Inode: 5001,
+				},
+			},
+		},
+		{
+			name: "ipv6",
+			data: header +
+				"   0: 0000000000000000FFFF00000500000A:0016 0000000000000000FFFF00000A0200C0:C350 01 00000000:00000000 " +
+				"02:000A7B8E 00000000     0        0 5001 4 0000000000000000 20 4 31 10 -1\n" +
+				"   1: B80D0120000000000000000005000000:0016 B80D0120000000000000000010000000:C351 01 00000000:00000000 " +
+				"02:000A7B8E 00000000  1000        0 5002 4 0000000000000000 20 4 31 10 -1\n",
+			want: []TCPSocket{
+				{
+					LocalIP: net.ParseIP("::ffff:10.0.0.5"), LocalPort: 22, RemoteIP: net.ParseIP("::ffff:192.0.2.10"), RemotePort: 50000,
+					State: TCPEstablished, Inode: 5001,
+				},
+				{
+					LocalIP: net.ParseIP("2001:db8::5"), LocalPort: 22, RemoteIP: net.ParseIP("2001:db8::10"), RemotePort: 50001,
+					State: TCPEstablished, UID: 1000, Inode: 5002,
+				},
+			},
+		},
+		{
+			name: "header only",
+			data: header,
+			want: []TCPSocket{},
+		},
+		{
+			name:    "too few fields",
+			data:    header + "   0: 00000000:0016 00000000:0000 0A\n",
+			wantErr: true,
+		},
+		{
+			name: "invalid address",
+			data: header +
+				"   0: 000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 4000 1 0000000000000000 100 0 0 10 0\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseTCPSockets(tc.data)
+			if tc.wantErr {
+				if !errors.Is(err, ErrBrokenNetTCP) {
+					t.Fatalf("expected ErrBrokenNetTCP, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("unexpected sockets: %+v", got)
+			}
+			for i, want := range tc.want {
+				g := got[i]
+				if !g.LocalIP.Equal(want.LocalIP) || g.LocalPort != want.LocalPort ||
+					!g.RemoteIP.Equal(want.RemoteIP) || g.RemotePort != want.RemotePort ||
+					g.State != want.State || g.UID != want.UID || g.Inode != want.Inode {
+					t.Errorf("unexpected socket:\n got: %+v\nwant: %+v", g, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseUnixSockets(t *testing.T) {
+	const header = "Num       RefCount Protocol Flags    Type St Inode Path\n"
+	testCases := []struct {
+		name    string
+		data    string
+		want    []UnixSocket
+		wantErr bool
+	}{
+		{
+			name: "sockets",
+			data: header +
+				"0000000000000000: 00000002 00000000 00010000 0001 01 6001 /tmp/tmux-1000/default\n" +
+				"0000000000000000: 00000003 00000000 00000000 0001 03 6002\n" +
+				"0000000000000000: 00000002 00000000 00010000 0001 01 6003 @/containerd-shim/abc.sock@\n" +
+				"0000000000000000: 00000002 00000000 00010000 0001 01 6004 /run/screen/S-alice/500.my job\n",
+			want: []UnixSocket{
+				{Listening: true, Inode: 6001, Path: "/tmp/tmux-1000/default"},
+				{Inode: 6002},
+				{Listening: true, Inode: 6003, Path: "@/containerd-shim/abc.sock@"},
+				{Listening: true, Inode: 6004, Path: "/run/screen/S-alice/500.my job"},
+			},
+		},
+		{
+			name:    "too few fields",
+			data:    header + "0000000000000000: 00000002 00000000 00010000\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid inode",
+			data:    header + "0000000000000000: 00000002 00000000 00010000 0001 01 x /tmp/a\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseUnixSockets(tc.data)
+			if tc.wantErr {
+				if !errors.Is(err, ErrBrokenNetUnix) {
+					t.Fatalf("expected ErrBrokenNetUnix, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("unexpected sockets: %+v", got)
+			}
+			for i, want := range tc.want {
+				if got[i] != want {
+					t.Errorf("unexpected socket:\n got: %+v\nwant: %+v", got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFSSocketInodes(t *testing.T) {
+	fs := NewFS("testdata/proc")
+	inodes, err := fs.SocketInodes(101)
+	if err != nil {
+		t.Fatalf("failed to read socket inodes: %v", err)
+	}
+	// the pipe and /dev/null are not sockets
+	if len(inodes) != 1 || inodes[0] != 5002 {
+		t.Errorf("unexpected socket inodes: %v", inodes)
+	}
+}
+
+func TestFSTCPSockets(t *testing.T) {
+	fs := NewFS("testdata/proc")
+	sockets, err := fs.TCPSockets(101)
+	if err != nil {
+		t.Fatalf("failed to read tcp sockets: %v", err)
+	}
+	// the sockets in tcp and tcp6 are concatenated
+	if len(sockets) != 3 || sockets[0].State != TCPListen || sockets[1].Inode != 5001 {
+		t.Errorf("unexpected tcp sockets: %+v", sockets)
+	}
+	if sockets[2].Inode != 5002 || !sockets[2].RemoteIP.Equal(net.ParseIP("2001:db8::10")) || sockets[2].RemotePort != 50001 {
+		t.Errorf("unexpected tcp6 socket: %+v", sockets[2])
+	}
+}
@@ -0,0 +1,238 @@
+package procfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClockTicks is the number of clock ticks per second (USER_HZ).
+// It is 100 on all architectures supported by Kubernetes.
+const ClockTicks = 100
+
+// minStatFields is the number of fields up to starttime, which have existed since Linux 2.6.
+const minStatFields = 22
+
+// ErrBrokenStat is returned when /proc/<pid>/stat cannot be parsed.
+var ErrBrokenStat = errors.New("broken process stat")
+
+// Stat represents the content of /proc/<pid>/stat.
+// See proc(5) for the meaning of each field.
+// The fields that are not provided by the running kernel are left zero.
+type Stat struct {
+	PID                 int
+	Comm                string
+	State               string
+	PPID                int
+	PGRP                int
+	Session             int
+	TTYNr               int
+	TPGID               int
+	Flags               uint
+	MinFlt              uint64
+	CMinFlt             uint64
+	MajFlt              uint64
+	CMajFlt             uint64
+	UTime               uint64
+	STime               uint64
+	CUTime              int64
+	CSTime              int64
+	Priority            int64
+	Nice                int64
+	NumThreads          int64
+	ITRealValue         int64
+	StartTime           uint64
+	VSize               uint64
+	RSS                 int64
+	RSSLim              uint64
+	StartCode           uint64
+	EndCode             uint64
+	StartStack          uint64
+	KStkESP             uint64
+	KStkEIP             uint64
+	Signal              uint64
+	Blocked             uint64
+	SigIgnore           uint64
+	SigCatch            uint64
+	WChan               uint64
+	NSwap               uint64
+	CNSwap              uint64
+	ExitSignal          int
+	Processor           int
+	RTPriority          uint
+	Policy              uint
+	DelayAcctBlkIOTicks uint64
+	GuestTime           uint64
+	CGuestTime          int64
+	StartData           uint64
+	EndData             uint64
+	StartBrk            uint64
+	ArgStart            uint64
+	ArgEnd              uint64
+	EnvStart            uint64
+	EnvEnd              uint64
+	ExitCode            int
+}
+
+// CPUTicks returns the CPU time consumed by the process in clock ticks.
+func (s *Stat) CPUTicks() uint64 {
+	return s.UTime + s.STime
+}
+
+// StartedAt returns the time the process started.
+func (s *Stat) StartedAt(bootTime time.Time) time.Time {
+	return bootTime.Add(time.Duration(s.StartTime) * time.Second / ClockTicks)
+}
+
+// Stat reads /proc/<pid>/stat.
+// If the process has already exited, an error satisfying IsNotExist is returned.
+func (f FS) Stat(pid int) (*Stat, error) {
+	statBytes, err := os.ReadFile(f.Path(strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseStat(string(statBytes))
+}
+
+// ParseStat parses the content of /proc/<pid>/stat.
+func ParseStat(data string) (*Stat, error) {
+	// The comm field is enclosed in parentheses, and may contain spaces and parentheses.
+	// Since no other field contains parentheses, the field ends at the last ')'.
+	open := strings.IndexByte(data, '(')
+	closing := strings.LastIndexByte(data, ')')
+	if open < 0 || closing < open {
+		return nil, fmt.Errorf("%w: comm field not found", ErrBrokenStat)
+	}
+
+	st := &Stat{
+		Comm: data[open+1 : closing],
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(data[:open]))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pid: %v", ErrBrokenStat, err)
+	}
+	st.PID = pid
+
+	fields := strings.Fields(data[closing+1:])
+	// The fields after comm start from the 3rd field.
+	if len(fields)+2 < minStatFields {
+		return nil, fmt.Errorf("%w: too few fields: %d", ErrBrokenStat, len(fields)+2)
+	}
+	st.State = fields[0]
+
+	p := &statParser{fields: fields[1:]}
+	p.intField(&st.PPID)
+	p.intField(&st.PGRP)
+	p.intField(&st.Session)
+	p.intField(&st.TTYNr)
+	p.intField(&st.TPGID)
+	p.uintField(&st.Flags)
+	p.uint64Field(&st.MinFlt)
+	p.uint64Field(&st.CMinFlt)
+	p.uint64Field(&st.MajFlt)
+	p.uint64Field(&st.CMajFlt)
+	p.uint64Field(&st.UTime)
+	p.uint64Field(&st.STime)
+	p.int64Field(&st.CUTime)
+	p.int64Field(&st.CSTime)
+	p.int64Field(&st.Priority)
+	p.int64Field(&st.Nice)
+	p.int64Field(&st.NumThreads)
+	p.int64Field(&st.ITRealValue)
+	p.uint64Field(&st.StartTime)
+	p.uint64Field(&st.VSize)
+	p.int64Field(&st.RSS)
+	p.uint64Field(&st.RSSLim)
+	p.uint64Field(&st.StartCode)
+	p.uint64Field(&st.EndCode)
+	p.uint64Field(&st.StartStack)
+	p.uint64Field(&st.KStkESP)
+	p.uint64Field(&st.KStkEIP)
+	p.uint64Field(&st.Signal)
+	p.uint64Field(&st.Blocked)
+	p.uint64Field(&st.SigIgnore)
+	p.uint64Field(&st.SigCatch)
+	p.uint64Field(&st.WChan)
+	p.uint64Field(&st.NSwap)
+	p.uint64Field(&st.CNSwap)
+	p.intField(&st.ExitSignal)
+	p.intField(&st.Processor)
+	p.uintField(&st.RTPriority)
+	p.uintField(&st.Policy)
+	p.uint64Field(&st.DelayAcctBlkIOTicks)
+	p.uint64Field(&st.GuestTime)
+	p.int64Field(&st.CGuestTime)
+	p.uint64Field(&st.StartData)
+	p.uint64Field(&st.EndData)
+	p.uint64Field(&st.StartBrk)
+	p.uint64Field(&st.ArgStart)
+	p.uint64Field(&st.ArgEnd)
+	p.uint64Field(&st.EnvStart)
+	p.uint64Field(&st.EnvEnd)
+	p.intField(&st.ExitCode)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return st, nil
+}
+
+// statParser parses the fields of /proc/<pid>/stat one by one.
+// Parsing stops at the first error, and missing trailing fields are left zero.
+type statParser struct {
+	fields []string
+	err    error
+}
+
+func (p *statParser) next() (string, bool) {
+	if p.err != nil || len(p.fields) == 0 {
+		return "", false
+	}
+	f := p.fields[0]
+	p.fields = p.fields[1:]
+	return f, true
+}
+
+func (p *statParser) setError(f string, err error) {
+	p.err = fmt.Errorf("%w: invalid field %q: %v", ErrBrokenStat, f, err)
+}
+
+func (p *statParser) intField(v *int) {
+	var n int64
+	p.int64Field(&n)
+	*v = int(n)
+}
+
+func (p *statParser) int64Field(v *int64) {
+	f, ok := p.next()
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseInt(f, 10, 64)
+	if err != nil {
+		p.setError(f, err)
+		return
+	}
+	*v = n
+}
+
+func (p *statParser) uintField(v *uint) {
+	var n uint64
+	p.uint64Field(&n)
+	*v = uint(n)
+}
+
+func (p *statParser) uint64Field(v *uint64) {
+	f, ok := p.next()
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseUint(f, 10, 64)
+	if err != nil {
+		p.setError(f, err)
+		return
+	}
+	*v = n
+}
@@ -0,0 +1,126 @@
+package procfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    string
+		want    *Stat
+		wantErr bool
+	}{
+		{
+			name: "simple command",
+			data: "100 (bash) S 1 100 100 34816 101 4194560 1200 300 0 0 25 12 0 0 20 0 1 0 123456 4620288 900 " +
+				"18446744073709551615 1 1 0 0 0 0 65536 3686404 1266761467 0 0 0 17 1 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+			want: &Stat{
+				PID: 100, Comm: "bash", State: "S", PPID: 1, PGRP: 100, Session: 100, TTYNr: 34816, TPGID: 101,
+				Flags: 4194560, MinFlt: 1200, CMinFlt: 300, UTime: 25, STime: 12, Priority: 20, NumThreads: 1,
+				StartTime: 123456, VSize: 4620288, RSS: 900, RSSLim: 18446744073709551615, StartCode: 1, EndCode: 1,
+				Blocked: 65536, SigIgnore: 3686404, SigCatch: 1266761467, ExitSignal: 17, Processor: 1,
+			},
+		},
+		{
+			name: "command containing spaces and parentheses",
+			data: "101 (tmux: (client) x) S 100 101 100 34816 101 4194304 150 0 0 0 3 1 0 0 20 0 1 0 123999 8192000 700 " +
+				"18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 2 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+			want: &Stat{
+				PID: 101, Comm: "tmux: (client) x", State: "S", PPID: 100, PGRP: 101, Session: 100, TTYNr: 34816, TPGID: 101,
+				Flags: 4194304, MinFlt: 150, UTime: 3, STime: 1, Priority: 20, NumThreads: 1,
+				StartTime: 123999, VSize: 8192000, RSS: 700, RSSLim: 18446744073709551615, StartCode: 1, EndCode: 1,
+				ExitSignal: 17, Processor: 2,
+			},
+		},
+		{
+			name: "negative values",
+			data: "7 (a) R 1 7 7 0 -1 0 0 0 0 0 0 0 -3 -4 -100 -20 1 0 10 0 0\n",
+			want: &Stat{
+				PID: 7, Comm: "a", State: "R", PPID: 1, PGRP: 7, Session: 7, TPGID: -1,
+				CUTime: -3, CSTime: -4, Priority: -100, Nice: -20, NumThreads: 1, StartTime: 10,
+			},
+		},
+		{
+			name: "old kernel providing only the mandatory fields",
+			data: "8 (old) S 1 8 8 0 -1 0 0 0 0 0 5 6 0 0 20 0 1 0 42",
+			want: &Stat{
+				PID: 8, Comm: "old", State: "S", PPID: 1, PGRP: 8, Session: 8, TPGID: -1,
+				UTime: 5, STime: 6, Priority: 20, NumThreads: 1, StartTime: 42,
+			},
+		},
+		{
+			name:    "empty",
+			data:    "",
+			wantErr: true,
+		},
+		{
+			name:    "no comm field",
+			data:    "9 bash S 1 9 9 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 42",
+			wantErr: true,
+		},
+		{
+			name:    "invalid pid",
+			data:    "x (bash) S 1 9 9 0 -1 0 0 0 0 0 0 0 0 0 20 0 1 0 42",
+			wantErr: true,
+		},
+		{
+			name:    "too few fields",
+			data:    "9 (bash) S 1 9 9 0 -1 0",
+			wantErr: true,
+		},
+		{
+			name:    "invalid number",
+			data:    "9 (bash) S 1 9 9 tty -1 0 0 0 0 0 0 0 0 0 20 0 1 0 42",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseStat(tc.data)
+			if tc.wantErr {
+				if !errors.Is(err, ErrBrokenStat) {
+					t.Fatalf("expected ErrBrokenStat, but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *got != *tc.want {
+				t.Errorf("unexpected stat:\n got: %+v\nwant: %+v", *got, *tc.want)
+			}
+		})
+	}
+}
+
+func TestFSStat(t *testing.T) {
+	fs := NewFS("testdata/proc")
+	bootTime := time.Unix(1700000000, 0)
+
+	st, err := fs.Stat(101)
+	if err != nil {
+		t.Fatalf("failed to read stat: %v", err)
+	}
+	if st.Comm != "sshd" || st.PPID != 1 || st.Session != 101 || st.TTYNr != 0 {
+		t.Errorf("unexpected stat: %+v", *st)
+	}
+	if st.CPUTicks() != 4 {
+		t.Errorf("unexpected cpu ticks: %d", st.CPUTicks())
+	}
+	if want := bootTime.Add(1239990 * time.Millisecond); !st.StartedAt(bootTime).Equal(want) {
+		t.Errorf("unexpected start time: %v", st.StartedAt(bootTime))
+	}
+
+	// a vanished process is not an error in the scan, but should be distinguishable
+	_, err = fs.Stat(12345)
+	if !IsNotExist(err) {
+		t.Errorf("expected not exist error, but got %v", err)
+	}
+	if IsNotExist(ErrBrokenStat) {
+		t.Error("broken stat should not be treated as a vanished process")
+	}
+}
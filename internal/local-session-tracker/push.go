@@ -0,0 +1,129 @@
+package local_session_tracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PushConfig represents the Pod whose login status is reported by the tracker itself,
+// and how to judge the login status, which corresponds to the annotations of the StatefulSet in the pull mode.
+type PushConfig struct {
+	// Method is how to report the login status, either common.PushModeAnnotation or common.PushModeLease.
+	Method       string
+	PodNamespace string
+	PodName      string
+	// PodUID is used to make the Pod the owner of the Lease. If empty, the Lease is left after the Pod is deleted.
+	PodUID string
+	// IdleTimeout is the duration after which an idle session is no longer considered as logged in. If zero, idle sessions are counted.
+	IdleTimeout time.Duration
+	// ProtectDetachedSessions means that the detached sessions of terminal multiplexers are considered as logged in.
+	ProtectDetachedSessions bool
+	// Interval is the interval to check the idle sessions, to renew the Lease and to retry a failed update.
+	Interval time.Duration
+	// LeaseDuration is how long the Lease is valid after it is renewed.
+	LeaseDuration time.Duration
+}
+
+// Pusher reports the login status of the Pod the tracker runs in, instead of being polled by login-protector.
+// It either updates the logged-in annotation of the Pod, which only needs the permission to patch Pods in the namespace,
+// or keeps renewing the Lease named after the Pod while someone is logged in.
+type Pusher struct {
+	config  PushConfig
+	tracker *Tracker
+	client  kubernetes.Interface
+	// pushed is the value of the annotation updated last time. It is empty until the first update succeeds.
+	pushed string
+}
+
+// NewPusher returns a Pusher.
+func NewPusher(config PushConfig, tracker *Tracker, client kubernetes.Interface) *Pusher {
+	return &Pusher{
+		config:  config,
+		tracker: tracker,
+		client:  client,
+	}
+}
+
+// Run reports the login status whenever the login status changes, until ctx is canceled.
+func (p *Pusher) Run(ctx context.Context, logger *zap.Logger) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		changed, err := p.push(ctx, logger)
+		if err != nil {
+			logger.Error("failed to push the login status", zap.Error(err))
+			pushErrorsCounter.Inc()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// push reports the login status. The annotation is updated only if the login status differs from the one reported last time,
+// while the Lease is renewed every time someone is logged in.
+// It returns the channel closed when the status changes.
+func (p *Pusher) push(ctx context.Context, logger *zap.Logger) (<-chan struct{}, error) {
+	changed := p.tracker.changes()
+	// The status older than MaxStaleness is not used, so that the Lease is not renewed while the scans are failing.
+	res, err := p.tracker.GetTTYStatus()
+	if err != nil {
+		return changed, err
+	}
+	// The login status is left unchanged since the sessions may be missing.
+	if res.Error != "" {
+		return changed, errors.New(res.Error)
+	}
+
+	value := common.ValueFalse
+	if res.CountActiveSessions(p.config.IdleTimeout, p.config.ProtectDetachedSessions) > 0 {
+		value = common.ValueTrue
+	}
+	if value == p.pushed && (p.config.Method != common.PushModeLease || value == common.ValueFalse) {
+		return changed, nil
+	}
+
+	if p.config.Method == common.PushModeLease {
+		err = p.renewLease(ctx, res, value == common.ValueTrue)
+	} else {
+		err = p.patchAnnotation(ctx, value)
+	}
+	if err != nil {
+		return changed, err
+	}
+	if value != p.pushed {
+		logger.Info("pushed the login status", zap.String("method", p.config.Method),
+			zap.String("namespace", p.config.PodNamespace), zap.String("pod", p.config.PodName),
+			zap.String("previous", p.pushed), zap.String("new", value))
+	}
+	p.pushed = value
+	return changed, nil
+}
+
+// patchAnnotation updates the logged-in annotation of the Pod.
+func (p *Pusher) patchAnnotation(ctx context.Context, value string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{
+				common.AnnotationLoggedIn: value,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.client.CoreV1().Pods(p.config.PodNamespace).Patch(ctx, p.config.PodName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -0,0 +1,189 @@
+package local_session_tracker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"go.uber.org/zap"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPush(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true})
+
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "target-sts-0"},
+	})
+	pusher := NewPusher(PushConfig{Method: common.PushModeAnnotation, PodNamespace: "default", PodName: "target-sts-0", ProtectDetachedSessions: true}, tracker, client)
+	ctx := context.Background()
+	loggedIn := func() string {
+		pod, err := client.CoreV1().Pods("default").Get(ctx, "target-sts-0", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pod.Annotations[common.AnnotationLoggedIn]
+	}
+
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	if v := loggedIn(); v != common.ValueTrue {
+		t.Errorf("unexpected annotation: %q", v)
+	}
+
+	// the annotation is not patched again while the login status is unchanged
+	client.ClearActions()
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("unexpected actions: %v", actions)
+	}
+
+	// the session has been closed
+	for _, pid := range []string{"100", "120", "121"} {
+		if err := os.RemoveAll(filepath.Join(procRoot, pid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tracker.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	if v := loggedIn(); v != common.ValueFalse {
+		t.Errorf("unexpected annotation: %q", v)
+	}
+}
+
+func TestPushLease(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true})
+
+	// the Lease left by the previous Pod
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "target-sts-0",
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       common.KindPod,
+				Name:       "target-sts-0",
+				UID:        "old-uid",
+			}},
+		},
+	})
+	pusher := NewPusher(PushConfig{
+		Method:                  common.PushModeLease,
+		PodNamespace:            "default",
+		PodName:                 "target-sts-0",
+		PodUID:                  "uid",
+		ProtectDetachedSessions: true,
+		LeaseDuration:           40 * time.Second,
+	}, tracker, client)
+	ctx := context.Background()
+	getLease := func() *coordinationv1.Lease {
+		lease, err := client.CoordinationV1().Leases("default").Get(ctx, "target-sts-0", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lease
+	}
+
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	lease := getLease()
+	if lease.Labels[common.LabelKeyLoginLease] != common.ValueTrue || len(lease.OwnerReferences) != 1 || lease.OwnerReferences[0].UID != "uid" {
+		t.Errorf("unexpected metadata: %+v", lease.ObjectMeta)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "alice" ||
+		lease.Spec.LeaseDurationSeconds == nil || *lease.Spec.LeaseDurationSeconds != 40 || lease.Spec.RenewTime == nil {
+		t.Errorf("unexpected spec: %+v", lease.Spec)
+	}
+	if v := lease.Annotations[common.AnnotationKeyLeaseSessions]; v != `[{"kind":"tty","user":"alice","tty":"pts/0"}]` {
+		t.Errorf("unexpected sessions: %s", v)
+	}
+	renewTime := lease.Spec.RenewTime.Time
+
+	// the Lease is renewed while the session exists
+	time.Sleep(10 * time.Millisecond)
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	lease = getLease()
+	if lease.Spec.RenewTime == nil || !lease.Spec.RenewTime.After(renewTime) {
+		t.Errorf("the Lease is not renewed: %+v", lease.Spec)
+	}
+
+	// the Lease is released after the session has been closed
+	for _, pid := range []string{"100", "120", "121"} {
+		if err := os.RemoveAll(filepath.Join(procRoot, pid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tracker.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+	lease = getLease()
+	if lease.Spec.HolderIdentity != nil || lease.Annotations[common.AnnotationKeyLeaseSessions] != "[]" {
+		t.Errorf("the Lease is not released: %+v", lease)
+	}
+}
+
+func TestPushLeaseScanFailure(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true})
+
+	client := fake.NewSimpleClientset()
+	pusher := NewPusher(PushConfig{
+		Method:                  common.PushModeLease,
+		PodNamespace:            "default",
+		PodName:                 "target-sts-0",
+		ProtectDetachedSessions: true,
+		LeaseDuration:           40 * time.Second,
+	}, tracker, client)
+	ctx := context.Background()
+
+	if _, err := pusher.push(ctx, zap.NewNop()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+
+	// the Lease is not renewed with the last successful scan once the scans start failing
+	if err := os.RemoveAll(procRoot); err != nil {
+		t.Fatal(err)
+	}
+	client.ClearActions()
+	if _, err := pusher.push(ctx, zap.NewNop()); err == nil {
+		t.Error("push should fail while the scan is failing")
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("unexpected action: %v", action)
+		}
+	}
+}
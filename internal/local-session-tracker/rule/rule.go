@@ -0,0 +1,178 @@
+// Package rule provides the rules written in CEL to decide which processes are counted as logins.
+package rule
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule is a named CEL expression evaluated against the attributes of a process.
+type Rule struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// Config represents the rules file.
+type Config struct {
+	// Include is the list of the rules to count the processes that are not detected otherwise.
+	Include []Rule `json:"include,omitempty"`
+	// Exclude is the list of the rules to ignore the processes. They take precedence over Include.
+	Exclude []Rule `json:"exclude,omitempty"`
+}
+
+// Attributes represents the attributes of a process available in the expressions.
+type Attributes struct {
+	Comm    string
+	Cmdline []string
+	// UID is the effective user ID, which is -1 if unknown.
+	UID       int64
+	User      string
+	TTY       string
+	Container string
+	Age       time.Duration
+	// Parent is nil if the parent is outside the PID namespace.
+	Parent *Attributes
+}
+
+type program struct {
+	name    string
+	program cel.Program
+}
+
+// Engine evaluates the compiled rules.
+type Engine struct {
+	include []program
+	exclude []program
+}
+
+// Load reads the rules file in YAML and compiles the rules.
+func Load(path string) (*Engine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return New(config)
+}
+
+// New compiles the rules.
+func New(config Config) (*Engine, error) {
+	env, err := newEnv()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	e := &Engine{}
+	for _, list := range []struct {
+		rules []Rule
+		dst   *[]program
+	}{
+		{config.Include, &e.include},
+		{config.Exclude, &e.exclude},
+	} {
+		for _, r := range list.rules {
+			if r.Name == "" {
+				return nil, errors.New("rule name is required")
+			}
+			if names[r.Name] {
+				return nil, fmt.Errorf("duplicate rule name: %s", r.Name)
+			}
+			names[r.Name] = true
+			p, err := compile(env, r.Expression)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule %s: %w", r.Name, err)
+			}
+			*list.dst = append(*list.dst, program{name: r.Name, program: p})
+		}
+	}
+	return e, nil
+}
+
+func newEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("comm", cel.StringType),
+		cel.Variable("cmdline", cel.ListType(cel.StringType)),
+		cel.Variable("uid", cel.IntType),
+		cel.Variable("user", cel.StringType),
+		cel.Variable("tty", cel.StringType),
+		cel.Variable("container", cel.StringType),
+		cel.Variable("age", cel.DurationType),
+		cel.Variable("parent", cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+	)
+}
+
+func compile(env *cel.Env, expr string) (cel.Program, error) {
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("expression must return bool, but returns %s", ast.OutputType())
+	}
+	return env.Program(ast)
+}
+
+// HasInclude returns true if any include rule is configured.
+func (e *Engine) HasInclude() bool {
+	return len(e.include) > 0
+}
+
+// Included returns the name of the first include rule matching the process.
+func (e *Engine) Included(a *Attributes) (string, bool) {
+	return match(e.include, a)
+}
+
+// Excluded returns the name of the first exclude rule matching the process.
+func (e *Engine) Excluded(a *Attributes) (string, bool) {
+	return match(e.exclude, a)
+}
+
+// match returns the first rule evaluated to true.
+// A rule failing to be evaluated, such as the one accessing out of the range of cmdline, does not match.
+func match(programs []program, a *Attributes) (string, bool) {
+	if len(programs) == 0 {
+		return "", false
+	}
+	vars := a.vars()
+	parent := &Attributes{UID: -1}
+	if a.Parent != nil {
+		parent = a.Parent
+	}
+	vars["parent"] = parent.vars()
+	for _, p := range programs {
+		out, _, err := p.program.Eval(vars)
+		if err != nil {
+			continue
+		}
+		if v, ok := out.Value().(bool); ok && v {
+			return p.name, true
+		}
+	}
+	return "", false
+}
+
+func (a *Attributes) vars() map[string]any {
+	cmdline := a.Cmdline
+	if cmdline == nil {
+		cmdline = []string{}
+	}
+	return map[string]any{
+		"comm":      a.Comm,
+		"cmdline":   cmdline,
+		"uid":       a.UID,
+		"user":      a.User,
+		"tty":       a.TTY,
+		"container": a.Container,
+		"age":       a.Age,
+	}
+}
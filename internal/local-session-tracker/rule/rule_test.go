@@ -0,0 +1,109 @@
+package rule
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	e, err := Load(filepath.Join("testdata", "rules.yaml"))
+	if err != nil {
+		t.Fatalf("failed to load rules: %v", err)
+	}
+	if !e.HasInclude() {
+		t.Error("include rules are not loaded")
+	}
+
+	testCases := []struct {
+		name         string
+		attrs        Attributes
+		wantIncluded string
+		wantExcluded string
+	}{
+		{
+			name:  "root shell",
+			attrs: Attributes{Comm: "bash", Cmdline: []string{"bash"}, UID: 0, TTY: "pts/0", Container: "main", Age: time.Hour},
+		},
+		{
+			name:         "human user",
+			attrs:        Attributes{Comm: "python3", Cmdline: []string{"python3", "job.py"}, UID: 1000, Container: "main"},
+			wantIncluded: "human-users",
+		},
+		{
+			name:         "sleep infinity",
+			attrs:        Attributes{Comm: "sleep", Cmdline: []string{"sleep", "infinity"}, UID: 1000, TTY: "pts/0"},
+			wantExcluded: "sleep-infinity",
+		},
+		{
+			// cmdline[1] is out of range, and the rule does not match.
+			name:  "sleep without arguments",
+			attrs: Attributes{Comm: "sleep", Cmdline: []string{"sleep"}, UID: 0},
+		},
+		{
+			name: "child of healthcheck",
+			attrs: Attributes{Comm: "sh", UID: 0, Parent: &Attributes{
+				Comm: "healthcheck", UID: 0,
+			}},
+			wantExcluded: "root-tooling",
+		},
+		{
+			name:         "new process in debug container",
+			attrs:        Attributes{Comm: "bash", UID: 1000, Container: "debug", Age: time.Minute},
+			wantIncluded: "human-users",
+			wantExcluded: "debug-container",
+		},
+		{
+			name:         "old process in debug container",
+			attrs:        Attributes{Comm: "bash", UID: 1000, Container: "debug", Age: 2 * time.Hour},
+			wantIncluded: "human-users",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, ok := e.Included(&tc.attrs)
+			if name != tc.wantIncluded || ok != (tc.wantIncluded != "") {
+				t.Errorf("unexpected include rule: %q", name)
+			}
+			name, ok = e.Excluded(&tc.attrs)
+			if name != tc.wantExcluded || ok != (tc.wantExcluded != "") {
+				t.Errorf("unexpected exclude rule: %q", name)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "no name",
+			config: Config{Include: []Rule{{Expression: "true"}}},
+		},
+		{
+			name:   "duplicate name",
+			config: Config{Include: []Rule{{Name: "a", Expression: "true"}}, Exclude: []Rule{{Name: "a", Expression: "false"}}},
+		},
+		{
+			name:   "syntax error",
+			config: Config{Exclude: []Rule{{Name: "a", Expression: "comm =="}}},
+		},
+		{
+			name:   "unknown attribute",
+			config: Config{Exclude: []Rule{{Name: "a", Expression: "pid == 1"}}},
+		},
+		{
+			name:   "not bool",
+			config: Config{Exclude: []Rule{{Name: "a", Expression: "comm"}}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(tc.config); err == nil {
+				t.Error("invalid rules should be an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package local_session_tracker
+
+import (
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/rule"
+)
+
+// applyRules removes the processes matching the exclude rules from procs, and adds the processes without TTY
+// matching the include rules as the sessions of SessionKindRule grouped by the session ID.
+// It returns the processes to be counted and the ones that would be counted but removed by the exclude rules.
+func (t *Tracker) applyRules(procs []*sessionProcess, noTTY []*procfs.Stat, stats map[int]*procfs.Stat, bootTime, now time.Time) ([]*sessionProcess, []common.Process, error) {
+	rules := t.config.Rules
+	res := make([]*sessionProcess, 0, len(procs))
+	excluded := make([]common.Process, 0)
+	detected := make(map[int]bool, len(procs))
+	apply := func(p *sessionProcess) {
+		attrs := t.ruleAttributes(stats[p.pid], stats, bootTime, now)
+		included, ok := rules.Included(attrs)
+		if !ok && p.kind == common.SessionKindRule {
+			return
+		}
+		if name, ok := rules.Excluded(attrs); ok {
+			p.Rule = name
+			excluded = append(excluded, p.Process)
+			return
+		}
+		p.Rule = included
+		res = append(res, p)
+	}
+
+	for _, p := range procs {
+		detected[p.pid] = true
+		apply(p)
+	}
+	if !rules.HasInclude() {
+		return res, excluded, nil
+	}
+	for _, st := range noTTY {
+		if detected[st.PID] {
+			continue
+		}
+		p, err := t.readProcess(st, bootTime, now)
+		if procfs.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == nil {
+			continue
+		}
+		p.kind = common.SessionKindRule
+		apply(p)
+	}
+	return res, excluded, nil
+}
+
+// ruleAttributes returns the attributes of the process and its parent evaluated by the rules.
+func (t *Tracker) ruleAttributes(st *procfs.Stat, stats map[int]*procfs.Stat, bootTime, now time.Time) *rule.Attributes {
+	attrs := t.processAttributes(st, bootTime, now)
+	if parent, ok := stats[st.PPID]; ok {
+		attrs.Parent = t.processAttributes(parent, bootTime, now)
+	}
+	return attrs
+}
+
+func (t *Tracker) processAttributes(st *procfs.Stat, bootTime, now time.Time) *rule.Attributes {
+	attrs := &rule.Attributes{
+		Comm:      st.Comm,
+		UID:       -1,
+		Container: t.containers.resolve(t.fs, st.PID).name,
+		Age:       now.Sub(st.StartedAt(bootTime)),
+	}
+	if uid, err := t.fs.UID(st.PID); err == nil {
+		attrs.UID = int64(uid)
+		attrs.User = t.users.resolve(t.fs, st.PID, uid)
+	}
+	if args, err := t.fs.Cmdline(st.PID); err == nil {
+		attrs.Cmdline = args
+	}
+	if st.TTYNr != 0 {
+		attrs.TTY = t.ttyName(st.PID, uint64(st.TTYNr))
+	}
+	return attrs
+}
@@ -1,19 +1,32 @@
 package local_session_tracker
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/hold"
 	"go.uber.org/zap"
 )
 
 type StatusHandler struct {
-	logger *zap.Logger
+	logger  *zap.Logger
+	tracker *Tracker
 }
 
-func NewStatusHandler(logger *zap.Logger) http.Handler {
+func NewStatusHandler(logger *zap.Logger, tracker *Tracker) http.Handler {
 	return &StatusHandler{
-		logger: logger,
+		logger:  logger,
+		tracker: tracker,
 	}
 }
 
@@ -24,7 +37,7 @@ func writeError(w http.ResponseWriter, err error) {
 }
 
 func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	res, err := getTTYStatus()
+	res, err := h.tracker.GetTTYStatus()
 	if err != nil {
 		h.logger.Error("failed to count ttys", zap.Error(err))
 		writeError(w, err)
@@ -39,3 +52,335 @@ func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 	w.Write(out) //nolint:errcheck
 }
+
+// Parameters of the watch API.
+const (
+	watchDefaultTimeout = 30 * time.Second
+	watchMaxTimeout     = 5 * time.Minute
+	// watchKeepAliveInterval is the interval of the comments sent to keep the event stream alive.
+	watchKeepAliveInterval = 15 * time.Second
+)
+
+// NewWatchHandler returns the handler of /status/watch, which notifies the changes of the state.
+//
+// If the request accepts "text/event-stream", the status is sent as Server-Sent Events of the type "status"
+// with the version as the ID, first the current one unless Last-Event-ID is the current version, and then on every change.
+// Otherwise, the request is a long-poll; it returns the status when the version differs from the "version" parameter
+// or the "timeout" parameter (default 30s) has passed.
+//
+// The state changes only by the periodic scans, so the handler responds 501 if ScanInterval is not configured.
+func NewWatchHandler(logger *zap.Logger, tracker *Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracker.config.ScanInterval <= 0 {
+			http.Error(w, "watching requires the periodic scans", http.StatusNotImplemented)
+			return
+		}
+		if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			serveEventStream(w, r, logger, tracker)
+			return
+		}
+		serveLongPoll(w, r, logger, tracker)
+	})
+}
+
+func serveLongPoll(w http.ResponseWriter, r *http.Request, logger *zap.Logger, tracker *Tracker) {
+	timeout := watchDefaultTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid timeout: "+v, http.StatusBadRequest)
+			return
+		}
+		timeout = min(d, watchMaxTimeout)
+	}
+	version := r.URL.Query().Get("version")
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		res, changed, err := tracker.Watch()
+		if err != nil {
+			logger.Error("failed to count ttys", zap.Error(err))
+			writeError(w, err)
+			return
+		}
+		if version != strconv.FormatUint(res.Version, 10) {
+			writeJSON(w, logger, http.StatusOK, res)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-timer.C:
+			writeJSON(w, logger, http.StatusOK, res)
+			return
+		case <-changed:
+		}
+	}
+}
+
+func serveEventStream(w http.ResponseWriter, r *http.Request, logger *zap.Logger, tracker *Tracker) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		logger.Error("streaming is not supported", zap.Error(err))
+		return
+	}
+
+	lastVersion := r.Header.Get("Last-Event-ID")
+	keepAlive := time.NewTicker(watchKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		res, changed, err := tracker.Watch()
+		if err != nil {
+			logger.Error("failed to count ttys", zap.Error(err))
+			return
+		}
+		if version := strconv.FormatUint(res.Version, 10); version != lastVersion {
+			data, err := json.Marshal(res)
+			if err != nil {
+				logger.Error("failed to marshal", zap.Error(err))
+				return
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: status\ndata: %s\n\n", version, data); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			lastVersion = version
+		}
+
+	wait:
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			case <-changed:
+				break wait
+			}
+		}
+	}
+}
+
+// NewReadinessHandler returns the handler of the readiness probe, which responds 503 if the tracker cannot detect sessions.
+func NewReadinessHandler(logger *zap.Logger, tracker *Tracker) http.Handler {
+	return newProbeHandler(logger, "readiness", tracker.Check)
+}
+
+// NewLivenessHandler returns the handler of the liveness probe, which responds 503 only if the tracker is stuck.
+// It does not fail for a misconfiguration such as an unshared PID namespace, which a restart cannot fix.
+func NewLivenessHandler(logger *zap.Logger, tracker *Tracker) http.Handler {
+	return newProbeHandler(logger, "liveness", tracker.Alive)
+}
+
+func newProbeHandler(logger *zap.Logger, probe string, check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			logger.Warn("health check failed", zap.String("probe", probe), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Add("Content-Type", "text/plain")
+		w.Write([]byte("ok")) //nolint:errcheck
+	})
+}
+
+// HoldRequest is the body of the request to add a hold.
+// The owner is not given by the client, but is the user of the client process.
+type HoldRequest struct {
+	Reason string `json:"reason"`
+	// TTL is the duration until the hold expires such as "2h". If empty, the hold never expires.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// NewHoldHandler returns the handler of the hold API for the store of the tracker.
+//
+//	GET    /holds       lists the active holds
+//	POST   /holds       adds a hold from a HoldRequest
+//	DELETE /holds/{id}  releases the hold
+//
+// The holds can be added or released only through the Unix socket served with ConnContextWithPeerCredentials,
+// so that only the processes in the Pod can keep it protected, and the owner is the user of the client process.
+// A hold can be released by its owner or root.
+func NewHoldHandler(logger *zap.Logger, tracker *Tracker) http.Handler {
+	store := tracker.HoldStore()
+	redact := func(h *common.Hold) {
+		if tracker.config.RedactUsers {
+			h.Owner = RedactedUser
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /holds", func(w http.ResponseWriter, r *http.Request) {
+		holds, err := store.List(time.Now())
+		if err != nil {
+			logger.Error("failed to list holds", zap.Error(err))
+			writeError(w, err)
+			return
+		}
+		for i := range holds {
+			redact(&holds[i])
+		}
+		writeJSON(w, logger, http.StatusOK, holds)
+	})
+	mux.HandleFunc("POST /holds", func(w http.ResponseWriter, r *http.Request) {
+		cred := peerCredentials(r.Context())
+		if cred == nil {
+			http.Error(w, "holds can be added only through the Unix socket", http.StatusForbidden)
+			return
+		}
+		var req HoldRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		h := common.Hold{
+			Reason: req.Reason,
+			Owner:  tracker.peerUser(cred),
+		}
+		if req.TTL != "" {
+			ttl, err := time.ParseDuration(req.TTL)
+			if err != nil || ttl <= 0 {
+				http.Error(w, "invalid ttl: "+req.TTL, http.StatusBadRequest)
+				return
+			}
+			expiresAt := now.Add(ttl)
+			h.ExpiresAt = &expiresAt
+		}
+		h, err := store.Add(h, now)
+		if errors.Is(err, hold.ErrNoReason) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to add a hold", zap.Error(err))
+			writeError(w, err)
+			return
+		}
+		logger.Info("hold added", zap.String("id", h.ID), zap.String("reason", h.Reason), zap.Uint32("uid", cred.Uid))
+		redact(&h)
+		writeJSON(w, logger, http.StatusCreated, h)
+	})
+	mux.HandleFunc("DELETE /holds/{id}", func(w http.ResponseWriter, r *http.Request) {
+		cred := peerCredentials(r.Context())
+		if cred == nil {
+			http.Error(w, "holds can be released only through the Unix socket", http.StatusForbidden)
+			return
+		}
+		id := r.PathValue("id")
+		var err error
+		if cred.Uid == 0 {
+			err = store.Remove(id, time.Now())
+		} else {
+			err = store.RemoveOwned(id, tracker.peerUser(cred), time.Now())
+		}
+		if errors.Is(err, hold.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, hold.ErrNotOwner) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to release a hold", zap.Error(err))
+			writeError(w, err)
+			return
+		}
+		logger.Info("hold released", zap.String("id", id), zap.Uint32("uid", cred.Uid))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, logger *zap.Logger, code int, v any) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("failed to marshal", zap.Error(err))
+		writeError(w, err)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(out) //nolint:errcheck
+}
+
+// tokenFile holds the token read from the file, and reads the file again when it is modified.
+type tokenFile struct {
+	path   string
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	stamp string
+	value string
+}
+
+// token returns the current token. The file is checked at every call, which is cheap enough compared to a request.
+// The previous token is kept if the file cannot be read.
+func (f *tokenFile) token() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		f.logger.Error("failed to reload bearer token", zap.String("file", f.path), zap.Error(err))
+	}
+	return f.value
+}
+
+func (f *tokenFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	stamp := fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size())
+	if stamp == f.stamp {
+		return nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return errors.New("empty token")
+	}
+	f.stamp, f.value = stamp, value
+	return nil
+}
+
+// NewBearerTokenHandler returns a handler that rejects the requests without the bearer token in path.
+// The file is read again when it is modified, so that the token can be rotated without restarting.
+func NewBearerTokenHandler(logger *zap.Logger, next http.Handler, path string) (http.Handler, error) {
+	f := &tokenFile{path: path, logger: logger}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(f.token())) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// NewClientCertHandler returns a handler that rejects the requests without a verified client certificate.
+func NewClientCertHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,75 @@
+package local_session_tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+)
+
+type peerCredentialsKey struct{}
+
+// SocketMode is the permission of the socket, which is shared by the containers in the Pod through the group given by fsGroup of the Pod.
+const SocketMode = 0660
+
+// ListenUnix listens on the Unix socket at path, which is shared with the other containers in the Pod through a volume.
+// The socket left by the previous process is replaced.
+func ListenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	case err == nil:
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, SocketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// ConnContextWithPeerCredentials stores the credentials of the process connected to a Unix socket in the context.
+// It is used as ConnContext of http.Server. The context is left unchanged for the other connections.
+func ConnContextWithPeerCredentials(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsKey{}, cred)
+}
+
+// peerCredentials returns the credentials of the client process. It returns nil if the request is not from the Unix socket.
+func peerCredentials(ctx context.Context) *syscall.Ucred {
+	cred, _ := ctx.Value(peerCredentialsKey{}).(*syscall.Ucred)
+	return cred
+}
+
+// peerUser returns the name of the user of the client process, which is resolved with /etc/passwd of its container.
+func (t *Tracker) peerUser(cred *syscall.Ucred) string {
+	return t.users.resolve(t.fs, int(cred.Pid), cred.Uid)
+}
@@ -0,0 +1,108 @@
+package local_session_tracker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"go.uber.org/zap"
+)
+
+func TestHoldHandler(t *testing.T) {
+	dir := t.TempDir()
+	tracker := NewTracker(Config{ProcRoot: "/proc", HoldFile: filepath.Join(dir, "holds.json")})
+	handler := NewHoldHandler(zap.NewNop(), tracker)
+
+	socket := filepath.Join(dir, "tracker.sock")
+	// a socket left by the previous process is replaced
+	for i := 0; i < 2; i++ {
+		ln, err := ListenUnix(socket)
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		if i == 0 {
+			// keep the socket file as if the process had been killed
+			ln.(*net.UnixListener).SetUnlinkOnClose(false)
+			ln.Close()
+			continue
+		}
+		server := &http.Server{Handler: handler, ConnContext: ConnContextWithPeerCredentials}
+		go server.Serve(ln) //nolint:errcheck
+		defer server.Close()
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != SocketMode {
+		t.Errorf("unexpected permission of the socket: %s", info.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	// the owner in the request is ignored
+	resp, err := client.Post("http://localhost/holds", "application/json",
+		strings.NewReader(`{"reason": "batch job", "owner": "mallory"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	var h common.Hold
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		want = u.Username
+	}
+	if h.Owner != want {
+		t.Errorf("unexpected owner: want %s, got %s", want, h.Owner)
+	}
+
+	// the holds cannot be modified over TCP
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/holds", strings.NewReader(`{"reason": "remote"}`)),
+		httptest.NewRequest(http.MethodDelete, "/holds/"+h.ID, nil),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s should be forbidden over TCP: %d", req.Method, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/holds", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), h.ID) {
+		t.Errorf("unexpected response to list holds over TCP: %d %s", rec.Code, rec.Body.String())
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, "http://localhost/holds/"+h.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("unexpected status code to release the hold: %d", resp.StatusCode)
+	}
+}
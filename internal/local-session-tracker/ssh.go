@@ -0,0 +1,93 @@
+package local_session_tracker
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+// sshSessionRegexp matches the process title of the per-session process of sshd such as "sshd: alice@pts/0",
+// "sshd: alice@notty" and "sshd-session: alice@pts/0,pts/1" (OpenSSH 9.8 or later).
+var sshSessionRegexp = regexp.MustCompile(`^sshd(?:-session)?: ([^@\s]+)@(\S+)`)
+
+// findSSHConnections finds the per-session processes of sshd and returns the map from their PIDs to the SSH connections.
+// The client address is left empty if the socket of the connection cannot be read.
+func (t *Tracker) findSSHConnections(stats map[int]*procfs.Stat) map[int]common.SSHConnection {
+	conns := make(map[int]common.SSHConnection)
+	for pid, st := range stats {
+		if st.Comm != "sshd" && st.Comm != "sshd-session" {
+			continue
+		}
+		args, err := t.fs.Cmdline(pid)
+		if err != nil {
+			continue
+		}
+		m := sshSessionRegexp.FindStringSubmatch(strings.Join(args, " "))
+		if m == nil {
+			continue
+		}
+		conn := common.SSHConnection{
+			PTY: m[2] != "notty",
+		}
+		// The socket is held by the per-session process and its parent, that is the privileged monitor.
+		for _, p := range []int{pid, st.PPID} {
+			if ip, port, ok := t.tcpRemoteAddr(p); ok {
+				conn.ClientIP = ip
+				conn.ClientPort = port
+				break
+			}
+		}
+		conns[pid] = conn
+	}
+	return conns
+}
+
+// tcpRemoteAddr returns the remote address of an established TCP connection held by the process.
+func (t *Tracker) tcpRemoteAddr(pid int) (string, int, bool) {
+	if pid <= 0 {
+		return "", 0, false
+	}
+	inodes, err := t.fs.SocketInodes(pid)
+	if err != nil || len(inodes) == 0 {
+		return "", 0, false
+	}
+	sockets, err := t.fs.TCPSockets(pid)
+	if err != nil {
+		return "", 0, false
+	}
+	for _, s := range sockets {
+		if s.State == procfs.TCPEstablished && slices.Contains(inodes, s.Inode) {
+			return s.RemoteIP.String(), s.RemotePort, true
+		}
+	}
+	return "", 0, false
+}
+
+// attachSSHConnections sets the SSH connections of the sessions started by sshd.
+// The connection of a session is the one of the nearest per-session process of sshd among the leader and its ancestors.
+func (t *Tracker) attachSSHConnections(sessions []common.Session, conns map[int]common.SSHConnection, stats map[int]*procfs.Stat) {
+	if len(conns) == 0 {
+		return
+	}
+	for i := range sessions {
+		pid, err := strconv.Atoi(sessions[i].Leader.PID)
+		if err != nil {
+			continue
+		}
+		for pid > 0 {
+			if conn, ok := conns[pid]; ok {
+				sessions[i].SSH = &conn
+				break
+			}
+			st, ok := stats[pid]
+			if !ok {
+				break
+			}
+			pid = st.PPID
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package local_session_tracker
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+// NewServerTLSConfig returns the TLS configuration to serve HTTPS with the certificate reloaded by reloader.
+// If the reloader has a CA bundle, the client certificates are verified with it.
+// The client certificates are not required in the handshake so that the probes by kubelet are accepted,
+// so the handlers should be wrapped by NewClientCertHandler.
+func NewServerTLSConfig(reloader *common.CertReloader) *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert := reloader.Certificate()
+		if cert == nil {
+			return nil, errors.New("no certificate")
+		}
+		return cert, nil
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool := reloader.CAPool()
+			if pool == nil {
+				// use the parent configuration.
+				return nil, nil
+			}
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+				ClientAuth:     tls.VerifyClientCertIfGiven,
+				ClientCAs:      pool,
+			}, nil
+		},
+	}
+}
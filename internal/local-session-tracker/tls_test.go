@@ -0,0 +1,153 @@
+package local_session_tracker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+// testCA is a CA to issue the certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a certificate and its private key signed by the CA to the files.
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64, usage x509.ExtKeyUsage, certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0644); err != nil {
+		t.Fatal(err)
+	}
+	serverCert, serverKey := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	ca.issue(t, "server-1", 2, x509.ExtKeyUsageServerAuth, serverCert, serverKey)
+	clientCert, clientKey := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth, clientCert, clientKey)
+
+	reloader, err := common.NewCertReloader(serverCert, serverKey, caFile, func(err error) {
+		t.Errorf("failed to reload: %v", err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(NewClientCertHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok")) //nolint:errcheck
+	})))
+	server.TLS = NewServerTLSConfig(reloader)
+	server.StartTLS()
+	defer server.Close()
+
+	clientReloader, err := common.NewCertReloader(clientCert, clientKey, caFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(withCert bool) (int, string) {
+		t.Helper()
+		config := &tls.Config{RootCAs: clientReloader.CAPool()}
+		if withCert {
+			config.Certificates = []tls.Certificate{*clientReloader.Certificate()}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode, resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	if code, _ := get(false); code != http.StatusUnauthorized {
+		t.Errorf("request without client certificate should be rejected: %d", code)
+	}
+	code, cn := get(true)
+	if code != http.StatusOK || cn != "server-1" {
+		t.Errorf("unexpected response: %d, %s", code, cn)
+	}
+
+	// the renewed certificate is served without restart
+	ca.issue(t, "server-2", 4, x509.ExtKeyUsageServerAuth, serverCert, serverKey)
+	future := time.Now().Add(time.Minute)
+	for _, f := range []string{serverCert, serverKey} {
+		if err := os.Chtimes(f, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if code, cn := get(true); code != http.StatusOK || cn != "server-2" {
+		t.Errorf("unexpected response after renewal: %d, %s", code, cn)
+	}
+}
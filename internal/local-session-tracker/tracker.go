@@ -1,89 +1,642 @@
 package local_session_tracker
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
-	"os/user"
-	"path/filepath"
+	"slices"
 	"strconv"
-	"strings"
-	"syscall"
+	"sync"
+	"time"
 
 	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/hold"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/rule"
+	"go.uber.org/zap"
 )
 
-var errProcStat = errors.New("broken process stat")
+// The backends to detect sessions.
+const (
+	// BackendProc detects the sessions by scanning the controlling terminals of the processes.
+	BackendProc = "proc"
+	// BackendUtmp detects the sessions by reading the login records in utmp or wtmp of the containers.
+	BackendUtmp = "utmp"
+)
+
+// RedactedUser is the user name shown instead of the actual one if RedactUsers is set.
+const RedactedUser = "<redacted>"
+
+// Config represents the configuration of Tracker.
+type Config struct {
+	// ProcRoot is the directory where procfs is mounted.
+	ProcRoot string
+	// Backend is the backend to detect sessions. If it is empty, BackendProc is used.
+	Backend string
+	// Simulated means that ProcRoot is a directory tree of fake /proc entries.
+	// In that case, the current time is derived from the boot time and the uptime in ProcRoot
+	// so that the status can be reproduced.
+	Simulated bool
+	// Containers is the list of container names whose sessions are counted.
+	// If it is empty, sessions in all containers are counted.
+	// Sessions whose container cannot be identified are always counted.
+	Containers []string
+	// InitTerminalIdleTimeout is the duration for which a session on the terminal of a container's init process
+	// (i.e. a container started with `tty: true`) is counted after the last input, which means someone is attached to it.
+	// If it is zero, such sessions are never counted.
+	InitTerminalIdleTimeout time.Duration
+	// DetectExecSessions means that the process trees started by `kubectl exec` without a terminal are reported as sessions.
+	// It is available only for BackendProc.
+	DetectExecSessions bool
+	// IgnoredExecCommands is the list of command names whose process trees are not reported as exec sessions,
+	// such as the commands of exec probes.
+	IgnoredExecCommands []string
+	// DetectSSHWithoutPTY means that the SSH sessions without a pseudo terminal, such as scp and rsync, are reported as sessions.
+	// It is available only for BackendProc.
+	DetectSSHWithoutPTY bool
+	// SessionPorts is the list of the local TCP ports such as the ones of web terminals and IDEs.
+	// Each established connection to them is reported as a session.
+	SessionPorts []int
+	// HoldFile is the path of the file to store holds. If it is empty, holds are not reported.
+	HoldFile string
+	// HoldEnv is the environment variable marking the processes to be reported as holds, either "NAME" or "NAME=VALUE".
+	// If it is empty, the environment variables are not read.
+	HoldEnv string
+	// Rules is the rules to include or exclude the processes. It is available only for BackendProc.
+	Rules *rule.Engine
+	// RedactUsers means that the user names in the status are replaced with RedactedUser.
+	// The rules are evaluated with the actual user names.
+	RedactUsers bool
+	// MaxStaleness is how long a scanned status is served by GetTTYStatus. If it is zero, every call scans the processes.
+	MaxStaleness time.Duration
+	// ScanInterval is the interval to scan the processes in Run. If it is zero, the processes are scanned only on demand.
+	ScanInterval time.Duration
+	// Logger reports the problems that do not fail the scan, such as a broken hold file. If it is nil, they are not reported.
+	Logger *zap.Logger
+}
+
+// Tracker observes the sessions in the Pod through procfs.
+type Tracker struct {
+	config     Config
+	logger     *zap.Logger
+	fs         procfs.FS
+	recorder   *activityRecorder
+	containers *containerResolver
+	users      *userResolver
+	holds      *hold.Store
+	// selfPID is the PID of the tracker itself. It is 0 if unknown, such as in the simulated mode.
+	selfPID int
+
+	mu sync.Mutex
+	// latest is the result of the last successful scan, and scannedAt is the wall-clock time of it.
+	latest    *common.TTYStatus
+	scannedAt time.Time
+	// inflight is the scan in progress.
+	inflight *scanCall
+	// version is incremented when the state in the scanned status changes, and changed is closed at the same time.
+	version uint64
+	changed chan struct{}
+}
+
+// scanCall represents a scan shared by the concurrent callers.
+type scanCall struct {
+	done      chan struct{}
+	startedAt time.Time
+	res       *common.TTYStatus
+	err       error
+}
+
+// NewTracker returns a Tracker.
+func NewTracker(config Config) *Tracker {
+	t := &Tracker{
+		config:     config,
+		logger:     config.Logger,
+		fs:         procfs.NewFS(config.ProcRoot),
+		recorder:   newActivityRecorder(),
+		containers: newContainerResolver(),
+		users:      newUserResolver(),
+		changed:    make(chan struct{}),
+	}
+	if t.logger == nil {
+		t.logger = zap.NewNop()
+	}
+	if config.HoldFile != "" {
+		t.holds = hold.NewStore(config.HoldFile)
+	}
+	if !config.Simulated {
+		t.selfPID = os.Getpid()
+	}
+	return t
+}
 
-// getTTYStatus returns the status of processes associated with TTY.
+// HoldStore returns the store of holds. It is nil if HoldFile is not configured.
+func (t *Tracker) HoldStore() *hold.Store {
+	return t.holds
+}
+
+// sessionProcess represents a process in a session.
+// For a session without TTY, ttyNr is 0 and sessionID is the PID of the root of the process tree.
+type sessionProcess struct {
+	common.Process
+	pid       int
+	ppid      int
+	sessionID int
+	kind      string
+	container containerInfo
+	ttyNr     uint64
+	cpuTicks  uint64
+}
+
+// sessionKey returns the key to group the process into a session.
+func (p *sessionProcess) sessionKey() string {
+	if p.kind != common.SessionKindTTY {
+		// the processes in such a session may have different terminals.
+		return fmt.Sprintf("%s/%d", p.kind, p.sessionID)
+	}
+	return fmt.Sprintf("%d/%d", p.sessionID, p.ttyNr)
+}
+
+// GetTTYStatus returns the status of sessions associated with TTY, the detached sessions of tmux and screen,
+// the exec sessions if DetectExecSessions is set, the SSH sessions without TTY if DetectSSHWithoutPTY is set,
+// and the TCP connections to SessionPorts. The holds are reported together and counted in Total.
+// With BackendUtmp, it returns the sessions of the users logged in according to the login records instead.
+// The status scanned within MaxStaleness is reused, and otherwise the processes are scanned by Scan.
 // NOTE: This implementation is for Linux.
-func getTTYStatus() (*common.TTYStatus, error) {
+func (t *Tracker) GetTTYStatus() (*common.TTYStatus, error) {
+	t.mu.Lock()
+	if t.latest != nil && time.Since(t.scannedAt) <= t.config.MaxStaleness {
+		res := t.latest
+		t.mu.Unlock()
+		return res, nil
+	}
+	t.mu.Unlock()
+	return t.Scan()
+}
+
+// Scan scans the processes regardless of the cached status.
+// If a scan is already in progress, it waits for the scan and returns the same result.
+// The returned status is shared by the callers, so it must not be modified.
+func (t *Tracker) Scan() (*common.TTYStatus, error) {
+	t.mu.Lock()
+	if c := t.inflight; c != nil {
+		t.mu.Unlock()
+		<-c.done
+		return c.res, c.err
+	}
+	c := &scanCall{done: make(chan struct{}), startedAt: time.Now()}
+	t.inflight = c
+	t.mu.Unlock()
+
+	c.res, c.err = t.scan()
+
+	t.mu.Lock()
+	t.inflight = nil
+	if c.err == nil {
+		if t.latest == nil || stateChanged(t.latest, c.res) {
+			t.version++
+			close(t.changed)
+			t.changed = make(chan struct{})
+		}
+		c.res.Version = t.version
+		t.latest = c.res
+		t.scannedAt = time.Now()
+	}
+	t.mu.Unlock()
+	close(c.done)
+	return c.res, c.err
+}
+
+// Run scans the processes every ScanInterval until ctx is canceled, so that GetTTYStatus serves the cached status.
+// It returns immediately if ScanInterval is zero.
+func (t *Tracker) Run(ctx context.Context, logger *zap.Logger) {
+	if t.config.ScanInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(t.config.ScanInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := t.Scan(); err != nil {
+			logger.Error("failed to scan processes", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan scans the processes and returns the status.
+func (t *Tracker) scan() (*common.TTYStatus, error) {
 	res := &common.TTYStatus{
 		Total:     0,
 		Processes: make([]common.Process, 0),
+		Sessions:  make([]common.Session, 0),
+		Holds:     make([]common.Hold, 0),
+	}
+
+	bootTime, err := t.fs.BootTime()
+	if err != nil {
+		return nil, err
+	}
+	now, err := t.now(bootTime)
+	if err != nil {
+		return nil, err
 	}
+	res.ScannedAt = now
 
-	dirs, err := os.ReadDir("/proc")
+	pids, err := t.fs.PIDs()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, d := range dirs {
-		err := func() error {
-			name := d.Name()
-			for _, ch := range name {
-				if ch < '0' || ch > '9' {
-					// if the name contains non-digit characters, it is not a process directory.
-					return nil
+	stats := make(map[int]*procfs.Stat, len(pids))
+	procs := make([]*sessionProcess, 0)
+	// orphans are the processes whose parent is outside the PID namespace,
+	// that is the init processes of the containers and the processes started by `kubectl exec`.
+	orphans := make([]*procfs.Stat, 0)
+	noTTY := make([]*procfs.Stat, 0)
+	for _, pid := range pids {
+		st, err := t.fs.Stat(pid)
+		if procfs.IsNotExist(err) {
+			// the process has exited during the scan.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats[pid] = st
+		if st.PPID == 0 {
+			orphans = append(orphans, st)
+		}
+		// If the controlling tty device number is 0, the process is not controlled.
+		if st.TTYNr == 0 {
+			noTTY = append(noTTY, st)
+			continue
+		}
+		p, err := t.readProcess(st, bootTime, now)
+		if procfs.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			procs = append(procs, p)
+		}
+	}
+	if err := t.checkPIDNamespace(pids); err != nil {
+		res.Error = err.Error()
+	}
+	inits := t.findContainerInits(orphans)
+	sshConns := t.findSSHConnections(stats)
+	switch t.config.Backend {
+	case BackendUtmp:
+		res.Sessions, err = t.readUtmpSessions(procs, stats, inits, bootTime, now)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		roots := make(map[int]string)
+		if t.config.DetectExecSessions {
+			for _, pid := range t.findExecRoots(noTTY, inits) {
+				roots[pid] = common.SessionKindExec
+			}
+		}
+		muxes := t.findMultiplexers(stats)
+		for pid, m := range muxes {
+			if !m.attached {
+				roots[pid] = common.SessionKindDetached
+			}
+		}
+		if t.config.DetectSSHWithoutPTY {
+			for pid, conn := range sshConns {
+				if !conn.PTY {
+					roots[pid] = common.SessionKindSSH
 				}
 			}
-			statFilePath := filepath.Join("/proc", name, "stat")
-			statBytes, err := os.ReadFile(statFilePath)
+		}
+		if len(roots) > 0 {
+			treeProcs, err := t.readTreeProcesses(noTTY, roots, bootTime, now)
 			if err != nil {
-				return err
+				return nil, err
 			}
-
-			stat := string(statBytes)
-			fields := strings.Split(stat, " ")
-			if len(fields) <= 6 {
-				return errProcStat
+			procs = append(procs, treeProcs...)
+		}
+		if t.config.Rules != nil {
+			procs, res.ExcludedProcesses, err = t.applyRules(procs, noTTY, stats, bootTime, now)
+			if err != nil {
+				return nil, err
 			}
+		}
+		t.foldDetachedSessions(procs, muxes, stats)
+		res.Sessions = t.groupSessions(procs, inits, now)
+		t.attachMultiplexers(res.Sessions, muxes)
+	}
+	if len(t.config.SessionPorts) > 0 {
+		netSessions, err := t.readNetworkSessions(stats, bootTime, now)
+		if err != nil {
+			return nil, err
+		}
+		res.Sessions = append(res.Sessions, netSessions...)
+	}
+	t.attachSSHConnections(res.Sessions, sshConns, stats)
+	var envHolds []common.Hold
+	if t.config.HoldEnv != "" {
+		envHolds, err = t.findEnvHolds(stats, bootTime, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.containers.flush()
+	t.users.flush()
+	t.recorder.flush()
 
-			// Get the owner of the process
-			info, err := os.Stat(statFilePath)
-			if err != nil {
-				return err
+	for _, s := range res.Sessions {
+		res.Processes = append(res.Processes, s.Processes...)
+	}
+	if t.holds != nil {
+		res.Holds, err = t.holds.List(now)
+		if errors.Is(err, hold.ErrBrokenFile) {
+			// a broken file written by someone should not stop reporting the sessions.
+			t.logger.Error("ignore the holds in the broken file", zap.Error(err))
+			res.Holds = make([]common.Hold, 0)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	res.Holds = append(res.Holds, envHolds...)
+	res.Total = len(res.Sessions) + len(res.Holds)
+	for _, s := range res.Sessions {
+		if res.OldestStartTime == nil || s.StartTime.Before(*res.OldestStartTime) {
+			startTime := s.StartTime
+			res.OldestStartTime = &startTime
+			res.OldestAgeSeconds = s.AgeSeconds
+		}
+	}
+
+	if t.config.RedactUsers {
+		redactUsers(res)
+	}
+	return res, nil
+}
+
+// redactUsers replaces the user names in the status with RedactedUser.
+func redactUsers(res *common.TTYStatus) {
+	redact := func(procs []common.Process) {
+		for i := range procs {
+			procs[i].User = RedactedUser
+		}
+	}
+	redact(res.Processes)
+	redact(res.ExcludedProcesses)
+	for i := range res.Sessions {
+		s := &res.Sessions[i]
+		s.User = RedactedUser
+		s.Leader.User = RedactedUser
+		redact(s.Processes)
+	}
+	for i := range res.Holds {
+		h := &res.Holds[i]
+		h.Owner = RedactedUser
+		if h.Process != nil {
+			p := *h.Process
+			p.User = RedactedUser
+			h.Process = &p
+		}
+	}
+}
+
+// readProcess reads the process information from /proc/<pid>.
+// If the process is not in the target containers, nil is returned.
+func (t *Tracker) readProcess(st *procfs.Stat, bootTime, now time.Time) (*sessionProcess, error) {
+	pid := st.PID
+	// Get the owner of the process
+	uid, err := t.fs.UID(pid)
+	if err != nil {
+		return nil, err
+	}
+	owner := t.users.resolve(t.fs, pid, uid)
+
+	container := t.containers.resolve(t.fs, pid)
+	if !t.isTargetContainer(container.name) {
+		return nil, nil
+	}
+
+	ttyNr := uint64(st.TTYNr)
+	tty := ""
+	if ttyNr != 0 {
+		tty = t.ttyName(pid, ttyNr)
+	}
+	startTime := st.StartedAt(bootTime)
+	return &sessionProcess{
+		Process: common.Process{
+			PID:         strconv.Itoa(pid),
+			Command:     st.Comm,
+			User:        owner,
+			TTY:         tty,
+			ContainerID: container.id,
+			Container:   container.name,
+			StartTime:   startTime,
+			AgeSeconds:  int64(now.Sub(startTime).Seconds()),
+		},
+		pid:       pid,
+		ppid:      st.PPID,
+		sessionID: st.Session,
+		kind:      common.SessionKindTTY,
+		container: container,
+		ttyNr:     ttyNr,
+		cpuTicks:  st.CPUTicks(),
+	}, nil
+}
+
+// findExecRoots returns the roots of the exec sessions, that is the processes without TTY
+// which have no parent in the shared PID namespace and are not the container init processes.
+func (t *Tracker) findExecRoots(stats []*procfs.Stat, inits map[int]bool) []int {
+	roots := make([]int, 0)
+	for _, st := range stats {
+		if st.PPID == 0 && !inits[st.PID] && !slices.Contains(t.config.IgnoredExecCommands, st.Comm) {
+			roots = append(roots, st.PID)
+		}
+	}
+	return roots
+}
+
+// readTreeProcesses reads the processes without TTY in the process trees of the given roots.
+// roots maps the PIDs of the roots to the kinds of their sessions.
+// If a process is in the trees of multiple roots, it belongs to the nearest one.
+// The sessionID and the kind of each process are set to the PID and the kind of the root.
+func (t *Tracker) readTreeProcesses(stats []*procfs.Stat, roots map[int]string, bootTime, now time.Time) ([]*sessionProcess, error) {
+	parents := make(map[int]int, len(stats))
+	for _, st := range stats {
+		parents[st.PID] = st.PPID
+	}
+
+	// rootOf caches the root of the tree each process belongs to. 0 means no tree.
+	rootOf := make(map[int]int, len(stats))
+	findRoot := func(pid int) int {
+		path := make([]int, 0)
+		root := 0
+		for {
+			if r, ok := rootOf[pid]; ok {
+				root = r
+				break
 			}
-			owner := "unknown"
-			if st, ok := info.Sys().(*syscall.Stat_t); ok {
-				uid := strconv.Itoa(int(st.Uid))
-				u, err := user.LookupId(uid)
-				if err != nil {
-					owner = uid
-				} else {
-					owner = u.Username
-				}
+			path = append(path, pid)
+			if _, ok := roots[pid]; ok {
+				root = pid
+				break
 			}
-
-			// The 1st (0-origin) field is the filename of the executable enclosed in parentheses.
-			tcomm := strings.Trim(strings.TrimLeft(fields[1], "("), ")")
-			// The 6th (0-origin) field is controlling tty device number.
-			// If it is "0", the process is not controlled.
-			ttyNumber := fields[6]
-			if ttyNumber != "0" {
-				p := common.Process{
-					PID:     name,
-					Command: tcomm,
-					User:    owner,
-				}
-				res.Total++
-				res.Processes = append(res.Processes, p)
+			// The ancestors with TTY are not in the map, so the processes spawned from a terminal are excluded.
+			ppid, ok := parents[pid]
+			if !ok || ppid == 0 {
+				break
 			}
-			return nil
-		}()
+			pid = ppid
+		}
+		for _, p := range path {
+			rootOf[p] = root
+		}
+		return root
+	}
+
+	procs := make([]*sessionProcess, 0)
+	for _, st := range stats {
+		root := findRoot(st.PID)
+		if root == 0 {
+			continue
+		}
+		p, err := t.readProcess(st, bootTime, now)
+		if procfs.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
+		if p != nil {
+			p.sessionID = root
+			p.kind = roots[root]
+			procs = append(procs, p)
+		}
 	}
+	return procs, nil
+}
 
-	return res, nil
+// groupSessions groups the processes by the session ID and the controlling terminal.
+// The sessions on the terminals of the container init processes are not counted unless someone is attached to them.
+func (t *Tracker) groupSessions(procs []*sessionProcess, inits map[int]bool, now time.Time) []common.Session {
+	keys := make([]string, 0)
+	sessions := make(map[string]*common.Session)
+	cpuTicks := make(map[string]uint64)
+	ttyDenied := make(map[string]bool)
+	initTerminals := make(map[string]bool)
+	for _, p := range procs {
+		key := p.sessionKey()
+		s, ok := sessions[key]
+		if !ok {
+			s = &common.Session{
+				ID:        strconv.Itoa(p.sessionID),
+				Kind:      p.kind,
+				Leader:    p.Process,
+				Processes: make([]common.Process, 0),
+				StartTime: p.StartTime,
+			}
+			if p.kind == common.SessionKindTTY {
+				s.TTY = p.TTY
+			}
+			sessions[key] = s
+			keys = append(keys, key)
+		}
+		s.Processes = append(s.Processes, p.Process)
+		cpuTicks[key] += p.cpuTicks
+
+		if p.pid == p.sessionID || (s.Leader.PID != s.ID && p.StartTime.Before(s.Leader.StartTime)) {
+			s.Leader = p.Process
+		}
+		if p.pid == p.sessionID && inits[p.pid] {
+			initTerminals[key] = true
+		}
+		if p.StartTime.Before(s.StartTime) {
+			s.StartTime = p.StartTime
+		}
+		// The terminal device may not be accessible through some processes, so try them one by one.
+		if s.LastInput == nil && p.ttyNr != 0 {
+			var denied bool
+			s.LastInput, s.LastOutput, denied = t.statTTYDevice(p.pid, p.ttyNr)
+			ttyDenied[key] = ttyDenied[key] || denied
+		}
+	}
+
+	lastCPUActive := t.recorder.update(cpuTicks, now)
+	res := make([]common.Session, 0, len(keys))
+	for _, key := range keys {
+		s := sessions[key]
+		if initTerminals[key] && !t.isAttached(s, now, ttyDenied[key]) {
+			continue
+		}
+		lastActive := latest(lastCPUActive[key].changedAt, s.LastInput, s.LastOutput)
+		s.User = s.Leader.User
+		s.Container = s.Leader.Container
+		setIdleSeconds(s, lastActive, now, ttyDenied[key])
+		s.AgeSeconds = int64(now.Sub(s.StartTime).Seconds())
+		res = append(res, *s)
+	}
+	return res
+}
+
+// isAttached returns true if the terminal of a container init process has received input recently,
+// which means someone is attached to it by `kubectl attach`.
+// If the terminal cannot be inspected because of the permission, someone may be attached to it, so it returns true.
+func (t *Tracker) isAttached(s *common.Session, now time.Time, ttyDenied bool) bool {
+	if t.config.InitTerminalIdleTimeout <= 0 {
+		return false
+	}
+	if s.LastInput == nil {
+		return ttyDenied
+	}
+	return now.Sub(*s.LastInput) < t.config.InitTerminalIdleTimeout
+}
+
+// findContainerInits returns the set of PIDs of the container init processes.
+// Both the init process and the processes started by `kubectl exec` have no parent in the shared PID namespace,
+// so the oldest one in each container is considered as the init process.
+func (t *Tracker) findContainerInits(orphans []*procfs.Stat) map[int]bool {
+	oldest := make(map[string]*procfs.Stat)
+	for _, st := range orphans {
+		key := t.containers.resolve(t.fs, st.PID).key
+		if o, ok := oldest[key]; !ok || st.StartTime < o.StartTime {
+			oldest[key] = st
+		}
+	}
+	inits := make(map[int]bool, len(oldest))
+	for _, st := range oldest {
+		inits[st.PID] = true
+	}
+	return inits
+}
+
+// now returns the current time.
+// In the simulation mode, it is the boot time plus the uptime in the fake procfs if available.
+func (t *Tracker) now(bootTime time.Time) (time.Time, error) {
+	if !t.config.Simulated {
+		return time.Now(), nil
+	}
+	uptime, err := t.fs.Uptime()
+	if errors.Is(err, fs.ErrNotExist) {
+		return time.Now(), nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return bootTime.Add(uptime), nil
+}
+
+// isTargetContainer returns true if the sessions in the container should be counted.
+func (t *Tracker) isTargetContainer(name string) bool {
+	if len(t.config.Containers) == 0 || name == "" {
+		return true
+	}
+	return slices.Contains(t.config.Containers, name)
 }
@@ -0,0 +1,790 @@
+package local_session_tracker
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/rule"
+)
+
+// sessionSummary represents the part of a session checked in the tests.
+type sessionSummary struct {
+	id string
+	// kind is derived from tty if empty
+	kind      string
+	leader    string
+	tty       string
+	user      string
+	container string
+	ssh       *common.SSHConnection
+	// multiplexer is the name of the multiplexer of a detached session
+	multiplexer string
+	pids        []string
+}
+
+func TestGetTTYStatus(t *testing.T) {
+	testCases := []struct {
+		name             string
+		fixture          string
+		backend          string
+		containers       []string
+		detectExec       bool
+		detectSSH        bool
+		sessionPorts     []int
+		ignoredExec      []string
+		wantSessions     []sessionSummary
+		wantOldestAgeSec int64
+	}{
+		{
+			name:         "no-session",
+			fixture:      "no-session",
+			wantSessions: []sessionSummary{},
+		},
+		{
+			name:    "single-session",
+			fixture: "single-session",
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/0", user: "alice", pids: []string{"100", "120", "121"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "multiple-sessions",
+			fixture: "multiple-sessions",
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/0", user: "alice", pids: []string{"100", "120"}},
+				{id: "200", leader: "200", tty: "pts/1", user: "0", pids: []string{"200", "201"}},
+			},
+			wantOldestAgeSec: 1500,
+		},
+		{
+			name:    "vanished-process",
+			fixture: "vanished-process",
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/0", user: "alice", pids: []string{"100"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "sessions in multiple containers",
+			fixture: "containers",
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/0", user: "alice", container: "main", pids: []string{"100", "120"}},
+				{id: "200", leader: "200", tty: "pts/1", user: "root", container: "debug", pids: []string{"200", "201"}},
+			},
+			wantOldestAgeSec: 1500,
+		},
+		{
+			name:       "sessions in the target containers",
+			fixture:    "containers",
+			containers: []string{"main", "sidecar"},
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/0", user: "alice", container: "main", pids: []string{"100", "120"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "terminal of a container started with tty",
+			fixture: "init-terminal",
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/1", user: "0", container: "main", pids: []string{"100"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "exec sessions are not detected by default",
+			fixture: "exec-session",
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/1", user: "0", container: "main", pids: []string{"100"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:       "exec sessions",
+			fixture:    "exec-session",
+			detectExec: true,
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/1", user: "0", container: "main", pids: []string{"100"}},
+				{id: "150", leader: "150", user: "0", container: "main", pids: []string{"150", "151"}},
+				{id: "160", leader: "160", user: "0", container: "main", pids: []string{"160", "161"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:        "exec sessions with ignored commands",
+			fixture:     "exec-session",
+			detectExec:  true,
+			ignoredExec: []string{"healthcheck"},
+			wantSessions: []sessionSummary{
+				{id: "100", leader: "100", tty: "pts/1", user: "0", container: "main", pids: []string{"100"}},
+				{id: "150", leader: "150", user: "0", container: "main", pids: []string{"150", "151"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "logins recorded in utmp",
+			fixture: "utmp",
+			backend: BackendUtmp,
+			wantSessions: []sessionSummary{
+				{id: "120", kind: "utmp", leader: "120", tty: "pts/0", user: "alice", container: "main", pids: []string{"120", "121"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "logins recorded in wtmp",
+			fixture: "wtmp",
+			backend: BackendUtmp,
+			wantSessions: []sessionSummary{
+				{id: "120", kind: "utmp", leader: "120", tty: "pts/0", user: "alice", container: "main", pids: []string{"120", "121"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:         "logins in the target containers",
+			fixture:      "utmp",
+			backend:      BackendUtmp,
+			containers:   []string{"sidecar"},
+			wantSessions: []sessionSummary{},
+		},
+		{
+			name:    "ssh sessions",
+			fixture: "ssh",
+			wantSessions: []sessionSummary{
+				{
+					id: "102", leader: "102", tty: "pts/0", user: "alice", container: "main",
+					ssh:  &common.SSHConnection{ClientIP: "192.0.2.10", ClientPort: 50000, PTY: true},
+					pids: []string{"102"},
+				},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:      "ssh sessions without pty",
+			fixture:   "ssh",
+			detectSSH: true,
+			wantSessions: []sessionSummary{
+				{
+					id: "102", leader: "102", tty: "pts/0", user: "alice", container: "main",
+					ssh:  &common.SSHConnection{ClientIP: "192.0.2.10", ClientPort: 50000, PTY: true},
+					pids: []string{"102"},
+				},
+				{
+					id: "201", kind: "ssh", leader: "201", user: "alice", container: "main",
+					ssh:  &common.SSHConnection{ClientIP: "2001:db8::10", ClientPort: 50001, PTY: false},
+					pids: []string{"201", "202", "203"},
+				},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:    "detached sessions of terminal multiplexers",
+			fixture: "multiplexer",
+			wantSessions: []sessionSummary{
+				{id: "300", kind: "detached", multiplexer: "tmux", leader: "300", user: "alice", container: "main", pids: []string{"301", "302", "300"}},
+				{id: "401", leader: "401", tty: "pts/3", user: "alice", container: "main", pids: []string{"401"}},
+				{id: "405", leader: "405", tty: "pts/4", user: "alice", container: "main", pids: []string{"405", "410", "510"}},
+				{id: "500", kind: "detached", multiplexer: "screen", leader: "500", user: "alice", container: "main", pids: []string{"501", "500"}},
+			},
+			wantOldestAgeSec: 1000,
+		},
+		{
+			name:         "connections to web terminals",
+			fixture:      "network",
+			sessionPorts: []int{8080},
+			wantSessions: []sessionSummary{
+				{id: "8080/192.0.2.10:50000", kind: "network", leader: "7", user: "alice", container: "main", pids: []string{"7"}},
+				// the owner of the socket is not visible
+				{id: "8080/192.0.2.20:50003", kind: "network", pids: []string{}},
+			},
+		},
+		{
+			name:         "no connections to the ports",
+			fixture:      "network",
+			sessionPorts: []int{8443},
+			wantSessions: []sessionSummary{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewTracker(Config{
+				ProcRoot:            filepath.Join("testdata", tc.fixture, "proc"),
+				Backend:             tc.backend,
+				Simulated:           true,
+				Containers:          tc.containers,
+				DetectExecSessions:  tc.detectExec,
+				DetectSSHWithoutPTY: tc.detectSSH,
+				SessionPorts:        tc.sessionPorts,
+				IgnoredExecCommands: tc.ignoredExec,
+			})
+			res, err := tracker.GetTTYStatus()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if res.Total != len(tc.wantSessions) {
+				t.Errorf("unexpected total: %d", res.Total)
+			}
+			if len(res.Sessions) != len(tc.wantSessions) {
+				t.Fatalf("unexpected sessions: %+v", res.Sessions)
+			}
+			numProcesses := 0
+			for i, want := range tc.wantSessions {
+				s := res.Sessions[i]
+				if s.ID != want.id || s.Leader.PID != want.leader || s.TTY != want.tty || s.Container != want.container {
+					t.Errorf("unexpected session: %+v", s)
+				}
+				wantKind := want.kind
+				if wantKind == "" {
+					wantKind = common.SessionKindTTY
+					if want.tty == "" {
+						wantKind = common.SessionKindExec
+					}
+				}
+				if s.Kind != wantKind {
+					t.Errorf("unexpected kind: %s", s.Kind)
+				}
+				if (s.SSH == nil) != (want.ssh == nil) || (s.SSH != nil && *s.SSH != *want.ssh) {
+					t.Errorf("unexpected ssh connection: %+v", s.SSH)
+				}
+				if (s.Multiplexer == nil && want.multiplexer != "") || (s.Multiplexer != nil && s.Multiplexer.Name != want.multiplexer) {
+					t.Errorf("unexpected multiplexer: %+v", s.Multiplexer)
+				}
+				if s.User != want.user || s.Leader.User != want.user {
+					t.Errorf("unexpected user: %s", s.User)
+				}
+				if len(s.Processes) != len(want.pids) {
+					t.Fatalf("unexpected processes: %+v", s.Processes)
+				}
+				for j, pid := range want.pids {
+					if s.Processes[j].PID != pid || s.Processes[j].Container != want.container {
+						t.Errorf("unexpected process: %+v", s.Processes[j])
+					}
+					// the processes in a detached session keep the terminals of the windows.
+					if wantKind != common.SessionKindDetached && s.Processes[j].TTY != want.tty {
+						t.Errorf("unexpected process tty: %+v", s.Processes[j])
+					}
+				}
+				numProcesses += len(want.pids)
+			}
+			if len(res.Processes) != numProcesses {
+				t.Errorf("unexpected processes: %+v", res.Processes)
+			}
+			if res.OldestAgeSeconds != tc.wantOldestAgeSec {
+				t.Errorf("unexpected oldest age: %d", res.OldestAgeSeconds)
+			}
+		})
+	}
+}
+
+func TestGetTTYStatusIdle(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true})
+
+	res, err := tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a session observed for the first time is considered to be active
+	if res.Sessions[0].IdleSeconds != 0 {
+		t.Errorf("unexpected idle seconds: %d", res.Sessions[0].IdleSeconds)
+	}
+
+	// 10 minutes have passed without consuming CPU time
+	err = os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("2600.00 9000.00\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Sessions[0].IdleSeconds != int64((10 * time.Minute).Seconds()) {
+		t.Errorf("unexpected idle seconds: %d", res.Sessions[0].IdleSeconds)
+	}
+	if res.OldestAgeSeconds != 1600 {
+		t.Errorf("unexpected oldest age: %d", res.OldestAgeSeconds)
+	}
+
+	// the CPU time consumed by vim makes the session active
+	stat, err := os.ReadFile(filepath.Join(procRoot, "120", "stat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat = []byte(strings.Replace(string(stat), " 100 0 0 0 10 5 ", " 100 0 0 0 20 5 ", 1))
+	if err := os.WriteFile(filepath.Join(procRoot, "120", "stat"), stat, 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("2700.00 9000.00\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Sessions[0].IdleSeconds != 0 {
+		t.Errorf("unexpected idle seconds after consuming CPU time: %d", res.Sessions[0].IdleSeconds)
+	}
+
+	err = os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("3000.00 9000.00\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Sessions[0].IdleSeconds != 300 {
+		t.Errorf("unexpected idle seconds: %d", res.Sessions[0].IdleSeconds)
+	}
+
+	// the CPU time is not persisted, so the idle time starts from 0 again after a restart
+	res, err = NewTracker(Config{ProcRoot: procRoot, Simulated: true}).GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Sessions[0].IdleSeconds != 0 {
+		t.Errorf("unexpected idle seconds after restart: %d", res.Sessions[0].IdleSeconds)
+	}
+}
+
+func TestGetTTYStatusCache(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true, MaxStaleness: time.Hour})
+
+	res1, err := tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res1.ScannedAt.Equal(time.Unix(1700000000+2000, 0)) {
+		t.Errorf("unexpected scannedAt: %s", res1.ScannedAt)
+	}
+
+	err = os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("2600.00 9000.00\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the cached status is served within the max staleness
+	res2, err := tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res2 != res1 {
+		t.Errorf("status is scanned again: %s", res2.ScannedAt)
+	}
+
+	// concurrent scans share the result
+	results := make(chan *common.TTYStatus, 10)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			res, err := tracker.Scan()
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results <- res
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		res := <-results
+		if res == nil || !res.ScannedAt.Equal(time.Unix(1700000000+2600, 0)) {
+			t.Errorf("unexpected status: %+v", res)
+		}
+	}
+
+	res3, err := tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res3.ScannedAt.Equal(time.Unix(1700000000+2600, 0)) {
+		t.Errorf("unexpected scannedAt: %s", res3.ScannedAt)
+	}
+}
+
+func TestGetTTYStatusHolds(t *testing.T) {
+	holdFile := filepath.Join(t.TempDir(), "holds.json")
+	tracker := NewTracker(Config{
+		ProcRoot:  filepath.Join("testdata", "no-session", "proc"),
+		Simulated: true,
+		HoldFile:  holdFile,
+	})
+
+	res, err := tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Total != 0 || len(res.Holds) != 0 {
+		t.Errorf("unexpected status: %+v", res)
+	}
+
+	// the current time of the fixture is btime + uptime
+	now := time.Unix(1700000000+2000, 0)
+	expired := now.Add(-time.Minute)
+	_, err = tracker.HoldStore().Add(common.Hold{Reason: "batch job", Owner: "alice"}, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hold of bob is added before it expires.
+	_, err = tracker.HoldStore().Add(common.Hold{Reason: "expired", Owner: "bob", ExpiresAt: &expired}, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err = tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Total != 1 || len(res.Sessions) != 0 {
+		t.Errorf("unexpected status: %+v", res)
+	}
+	if len(res.Holds) != 1 || res.Holds[0].Reason != "batch job" || res.Holds[0].Owner != "alice" {
+		t.Errorf("unexpected holds: %+v", res.Holds)
+	}
+
+	// a broken file is ignored instead of failing the scan
+	if err := os.WriteFile(holdFile, []byte(`{"holds": [`), 0660); err != nil {
+		t.Fatal(err)
+	}
+	res, err = tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("broken hold file should not fail the scan: %v", err)
+	}
+	if res.Total != 0 || len(res.Holds) != 0 {
+		t.Errorf("unexpected status with broken hold file: %+v", res)
+	}
+}
+
+func TestGetTTYStatusEnvHolds(t *testing.T) {
+	testCases := []struct {
+		name       string
+		holdEnv    string
+		containers []string
+		// wantHolds is the list of "<pid> <reason>"
+		wantHolds []string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:      "any value",
+			holdEnv:   "LOGIN_PROTECTOR_HOLD",
+			wantHolds: []string{"150 LOGIN_PROTECTOR_HOLD=nightly backup", "161 LOGIN_PROTECTOR_HOLD=1", "170 LOGIN_PROTECTOR_HOLD=1"},
+		},
+		{
+			name:      "exact value",
+			holdEnv:   "LOGIN_PROTECTOR_HOLD=1",
+			wantHolds: []string{"161 LOGIN_PROTECTOR_HOLD=1", "170 LOGIN_PROTECTOR_HOLD=1"},
+		},
+		{
+			name:       "target containers",
+			holdEnv:    "LOGIN_PROTECTOR_HOLD=1",
+			containers: []string{"main"},
+			wantHolds:  []string{"161 LOGIN_PROTECTOR_HOLD=1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewTracker(Config{
+				ProcRoot:   filepath.Join("testdata", "env-hold", "proc"),
+				Simulated:  true,
+				Containers: tc.containers,
+				HoldEnv:    tc.holdEnv,
+			})
+			res, err := tracker.GetTTYStatus()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// the session of pts/1 is always counted
+			if res.Total != len(tc.wantHolds)+1 {
+				t.Errorf("unexpected total: %d", res.Total)
+			}
+			if len(res.Holds) != len(tc.wantHolds) {
+				t.Fatalf("unexpected holds: %+v", res.Holds)
+			}
+			for i, want := range tc.wantHolds {
+				h := res.Holds[i]
+				if h.Process == nil {
+					t.Fatalf("process is not set: %+v", h)
+				}
+				if h.Process.PID+" "+h.Reason != want || h.ID != "pid/"+h.Process.PID || h.Owner != "0" {
+					t.Errorf("unexpected hold: %+v", h)
+				}
+				if !h.CreatedAt.Equal(h.Process.StartTime) {
+					t.Errorf("unexpected creation time: %s", h.CreatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestGetTTYStatusRules(t *testing.T) {
+	rules, err := rule.New(rule.Config{
+		Include: []rule.Rule{
+			{Name: "human-users", Expression: "uid >= 1000"},
+		},
+		Exclude: []rule.Rule{
+			{Name: "sleep-infinity", Expression: `cmdline == ["sleep", "infinity"]`},
+			{Name: "healthcheck", Expression: `comm == "healthcheck" || parent.comm == "healthcheck"`},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		detectExec bool
+		// wantSessions is the list of "<kind> <id> <pids with rules>"
+		wantSessions []string
+		// wantExcluded is the list of "<pid>:<rule>"
+		wantExcluded []string
+	}{
+		{
+			name: "include processes without tty",
+			// uid is the effective user ID, so sudo run by a human user is not included, but the worker switched to it is.
+			wantSessions: []string{
+				"tty 100 [100]",
+				"rule 151 [151:human-users]",
+				"rule 153 [153:human-users]",
+			},
+			wantExcluded: []string{"101:sleep-infinity"},
+		},
+		{
+			name:       "exec sessions",
+			detectExec: true,
+			wantSessions: []string{
+				"tty 100 [100]",
+				"exec 150 [150 151:human-users 152 153:human-users]",
+			},
+			wantExcluded: []string{"101:sleep-infinity", "160:healthcheck", "161:healthcheck"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewTracker(Config{
+				ProcRoot:           filepath.Join("testdata", "rules", "proc"),
+				Simulated:          true,
+				DetectExecSessions: tc.detectExec,
+				Rules:              rules,
+			})
+			res, err := tracker.GetTTYStatus()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			sessions := make([]string, 0, len(res.Sessions))
+			for _, s := range res.Sessions {
+				pids := make([]string, 0, len(s.Processes))
+				for _, p := range s.Processes {
+					pids = append(pids, processWithRule(p))
+				}
+				sessions = append(sessions, fmt.Sprintf("%s %s %v", s.Kind, s.ID, pids))
+			}
+			if !slices.Equal(sessions, tc.wantSessions) {
+				t.Errorf("unexpected sessions: %q", sessions)
+			}
+			if res.Total != len(tc.wantSessions) {
+				t.Errorf("unexpected total: %d", res.Total)
+			}
+
+			excluded := make([]string, 0, len(res.ExcludedProcesses))
+			for _, p := range res.ExcludedProcesses {
+				excluded = append(excluded, processWithRule(p))
+			}
+			if !slices.Equal(excluded, tc.wantExcluded) {
+				t.Errorf("unexpected excluded processes: %q", excluded)
+			}
+		})
+	}
+}
+
+func TestGetTTYStatusRedactUsers(t *testing.T) {
+	tracker := NewTracker(Config{
+		ProcRoot:    filepath.Join("testdata", "env-hold", "proc"),
+		Simulated:   true,
+		HoldEnv:     "LOGIN_PROTECTOR_HOLD",
+		RedactUsers: true,
+	})
+	res, err := tracker.GetTTYStatus()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res.Sessions) == 0 || len(res.Holds) == 0 {
+		t.Fatalf("unexpected status: %+v", res)
+	}
+	for _, s := range res.Sessions {
+		if s.User != RedactedUser || s.Leader.User != RedactedUser || s.Processes[0].User != RedactedUser {
+			t.Errorf("user is not redacted: %+v", s)
+		}
+	}
+	for _, p := range res.Processes {
+		if p.User != RedactedUser {
+			t.Errorf("user is not redacted: %+v", p)
+		}
+	}
+	for _, h := range res.Holds {
+		if h.Owner != RedactedUser || h.Process.User != RedactedUser {
+			t.Errorf("owner is not redacted: %+v", h)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	testCases := []struct {
+		name    string
+		fixture string
+		selfPID int
+		wantErr error
+	}{
+		{
+			name:    "shared PID namespace",
+			fixture: "exec-session",
+			selfPID: 8,
+		},
+		{
+			name:    "PID namespace not shared",
+			fixture: "unshared-pid-namespace",
+			selfPID: 1,
+			wantErr: ErrPIDNamespaceNotShared,
+		},
+		{
+			name:    "unknown cgroup",
+			fixture: "no-session",
+			selfPID: 8,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewTracker(Config{
+				ProcRoot:  filepath.Join("testdata", tc.fixture, "proc"),
+				Simulated: true,
+			})
+			tracker.selfPID = tc.selfPID
+
+			err := tracker.Check()
+			if (err == nil) != (tc.wantErr == nil) || (err != nil && err.Error() != tc.wantErr.Error()) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			res, err := tracker.GetTTYStatus()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (tc.wantErr == nil && res.Error != "") || (tc.wantErr != nil && res.Error != tc.wantErr.Error()) {
+				t.Errorf("unexpected error in status: %s", res.Error)
+			}
+		})
+	}
+
+	tracker := NewTracker(Config{ProcRoot: filepath.Join("testdata", "missing", "proc"), Simulated: true})
+	if err := tracker.Check(); err == nil {
+		t.Error("missing procfs should be an error")
+	}
+}
+
+func TestIsAttached(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	recent, old := now.Add(-time.Minute), now.Add(-time.Hour)
+
+	testCases := []struct {
+		name      string
+		timeout   time.Duration
+		lastInput *time.Time
+		denied    bool
+		want      bool
+	}{
+		{name: "recent input", timeout: 5 * time.Minute, lastInput: &recent, want: true},
+		{name: "old input", timeout: 5 * time.Minute, lastInput: &old},
+		{name: "no terminal on the standard file descriptors", timeout: 5 * time.Minute},
+		{name: "terminal not accessible", timeout: 5 * time.Minute, denied: true, want: true},
+		{name: "disabled", lastInput: &recent, denied: true},
+	}
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := NewTracker(Config{InitTerminalIdleTimeout: tt.timeout})
+			if got := tracker.isAttached(&common.Session{LastInput: tt.lastInput}, now, tt.denied); got != tt.want {
+				t.Errorf("unexpected result: %v", got)
+			}
+		})
+	}
+}
+
+func TestAlive(t *testing.T) {
+	// a misconfiguration does not make the tracker dead
+	tracker := NewTracker(Config{ProcRoot: filepath.Join("testdata", "unshared-pid-namespace", "proc"), Simulated: true})
+	tracker.selfPID = 1
+	if err := tracker.Check(); err == nil {
+		t.Error("unshared PID namespace should fail the check")
+	}
+	if err := tracker.Alive(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	tracker = NewTracker(Config{ProcRoot: filepath.Join("testdata", "missing", "proc"), Simulated: true})
+	if err := tracker.Alive(); err != nil {
+		t.Errorf("unexpected error for missing procfs: %v", err)
+	}
+
+	// a scan running for a while is fine, but a stuck one is not
+	tracker.inflight = &scanCall{done: make(chan struct{}), startedAt: time.Now().Add(-time.Second)}
+	if err := tracker.Alive(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	tracker.inflight.startedAt = time.Now().Add(-2 * stuckScanTimeout)
+	if err := tracker.Alive(); err == nil {
+		t.Error("stuck scan should be an error")
+	}
+}
+
+func processWithRule(p common.Process) string {
+	if p.Rule == "" {
+		return p.PID
+	}
+	return p.PID + ":" + p.Rule
+}
+
+// copyDir copies a fixture directory so that the test can modify it.
+func copyDir(dst, src string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
@@ -0,0 +1,63 @@
+package local_session_tracker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Major device numbers of terminals.
+// See https://www.kernel.org/doc/Documentation/admin-guide/devices.txt
+const (
+	ttyMajor        = 4
+	ttyAuxMajor     = 5
+	ptsMajorFirst   = 136
+	ptsMajorLast    = 143
+	ttyMinorsPerVT  = 64
+	ptsMinorsPerMaj = 256
+)
+
+// decodeDevice splits the device number encoded by the kernel into the major and minor numbers.
+func decodeDevice(dev uint64) (major, minor uint64) {
+	major = (dev >> 8) & 0xfff
+	minor = (dev & 0xff) | ((dev >> 12) & 0xfff00)
+	return major, minor
+}
+
+// ttyName returns the name of the controlling terminal such as "pts/3" or "tty1".
+// If the device number is not a well-known terminal, the name is looked up from
+// the standard file descriptors of the process.
+// If it cannot be found either, "<major>:<minor>" is returned.
+func (t *Tracker) ttyName(pid int, ttyNr uint64) string {
+	major, minor := decodeDevice(ttyNr)
+	switch {
+	case major >= ptsMajorFirst && major <= ptsMajorLast:
+		return fmt.Sprintf("pts/%d", (major-ptsMajorFirst)*ptsMinorsPerMaj+minor)
+	case major == ttyMajor && minor < ttyMinorsPerVT:
+		return fmt.Sprintf("tty%d", minor)
+	case major == ttyMajor:
+		return fmt.Sprintf("ttyS%d", minor-ttyMinorsPerVT)
+	case major == ttyAuxMajor && minor == 1:
+		return "console"
+	}
+
+	for fd := 0; fd <= 2; fd++ {
+		fdPath := t.fs.Path(strconv.Itoa(pid), "fd", strconv.Itoa(fd))
+		info, err := os.Stat(fdPath)
+		if err != nil {
+			continue
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || st.Rdev != ttyNr {
+			continue
+		}
+		link, err := os.Readlink(fdPath)
+		if err != nil || !strings.HasPrefix(link, "/dev/") {
+			continue
+		}
+		return strings.TrimPrefix(link, "/dev/")
+	}
+	return fmt.Sprintf("%d:%d", major, minor)
+}
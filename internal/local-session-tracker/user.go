@@ -0,0 +1,127 @@
+package local_session_tracker
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+)
+
+// passwdFile represents the content of /etc/passwd in a container.
+type passwdFile struct {
+	modTime time.Time
+	size    int64
+	users   map[uint32]string
+}
+
+// userResolver resolves user IDs into usernames using /etc/passwd of the container the process belongs to,
+// because the user database of the tracker image has nothing to do with the one of the other containers.
+// The content of /etc/passwd is cached by the mount namespace of the process, and is reloaded when the file is updated.
+type userResolver struct {
+	mu    sync.Mutex
+	cache map[string]*passwdFile
+	seen  map[string]*passwdFile
+}
+
+func newUserResolver() *userResolver {
+	return &userResolver{
+		cache: make(map[string]*passwdFile),
+		seen:  make(map[string]*passwdFile),
+	}
+}
+
+// resolve returns the username of the user ID seen from the process.
+// If /etc/passwd of the process cannot be read or does not contain the user ID, the numeric user ID is returned.
+func (r *userResolver) resolve(fs procfs.FS, pid int, uid uint32) string {
+	numeric := strconv.FormatUint(uint64(uid), 10)
+
+	passwdPath := fs.RootPath(pid, "etc", "passwd")
+	key, err := fs.MountNamespace(pid)
+	if err != nil {
+		// the namespace is unknown, so the cache cannot be shared with other processes.
+		key = passwdPath
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// /etc/passwd is checked for update only once per scan for each mount namespace.
+	passwd, ok := r.seen[key]
+	if !ok {
+		passwd = r.load(passwdPath, r.cache[key])
+		r.seen[key] = passwd
+	}
+	if passwd == nil {
+		return numeric
+	}
+	if name, ok := passwd.users[uid]; ok {
+		return name
+	}
+	return numeric
+}
+
+// load reads the passwd file unless the cached one is up to date. It returns nil if the file cannot be read.
+func (r *userResolver) load(path string, cached *passwdFile) *passwdFile {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	if cached != nil && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached
+	}
+	users, err := readPasswd(path)
+	if err != nil {
+		return nil
+	}
+	return &passwdFile{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		users:   users,
+	}
+}
+
+// flush forgets the mount namespaces that were not seen since the last flush.
+func (r *userResolver) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = r.seen
+	r.seen = make(map[string]*passwdFile)
+}
+
+// readPasswd reads a passwd file and returns the map from user IDs to usernames.
+// Each line is "name:password:UID:GID:GECOS:directory:shell".
+func readPasswd(path string) (map[uint32]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[uint32]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		uid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		// the first entry wins like getpwuid(3)
+		if _, ok := users[uint32(uid)]; !ok {
+			users[uint32(uid)] = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,121 @@
+package local_session_tracker
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/procfs"
+	"github.com/cybozu-go/login-protector/internal/local-session-tracker/utmp"
+)
+
+// readUtmpSessions returns the sessions of the users logged in according to utmp of the containers.
+// If utmp does not exist in a container, the logins are replayed from wtmp.
+// The records whose login process has exited are ignored because they are left by crashed daemons.
+// The members of a session are the login process and the processes on the terminal of the login.
+func (t *Tracker) readUtmpSessions(procs []*sessionProcess, stats map[int]*procfs.Stat, inits map[int]bool, bootTime, now time.Time) ([]common.Session, error) {
+	initPIDs := make([]int, 0, len(inits))
+	for pid := range inits {
+		initPIDs = append(initPIDs, pid)
+	}
+	slices.Sort(initPIDs)
+
+	sessions := make([]common.Session, 0)
+	keys := make([]string, 0)
+	cpuTicks := make(map[string]uint64)
+	ttyDenied := make(map[string]bool)
+	// the containers may share the root directory, so read the records once per mount namespace.
+	seen := make(map[string]bool)
+	for _, pid := range initPIDs {
+		container := t.containers.resolve(t.fs, pid)
+		if !t.isTargetContainer(container.name) {
+			continue
+		}
+		ns, err := t.fs.MountNamespace(pid)
+		if err != nil {
+			ns = strconv.Itoa(pid)
+		}
+		if seen[ns] {
+			continue
+		}
+		seen[ns] = true
+
+		records, err := t.readLoginRecords(pid)
+		if procfs.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			st, ok := stats[int(r.PID)]
+			if !ok {
+				continue
+			}
+			leader, err := t.readProcess(st, bootTime, now)
+			if procfs.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if leader == nil {
+				continue
+			}
+
+			key := fmt.Sprintf("%d/%s", r.PID, r.Line)
+			s := common.Session{
+				ID:        strconv.Itoa(int(r.PID)),
+				Kind:      common.SessionKindUtmp,
+				Leader:    leader.Process,
+				User:      r.User,
+				TTY:       r.Line,
+				Container: leader.Container,
+				Host:      r.Host,
+				Processes: []common.Process{leader.Process},
+				StartTime: r.Time,
+			}
+			cpuTicks[key] = leader.cpuTicks
+			for _, p := range procs {
+				if p.pid == leader.pid || p.TTY != r.Line || p.container.key != leader.container.key {
+					continue
+				}
+				s.Processes = append(s.Processes, p.Process)
+				cpuTicks[key] += p.cpuTicks
+				if s.LastInput == nil {
+					var denied bool
+					s.LastInput, s.LastOutput, denied = t.statTTYDevice(p.pid, p.ttyNr)
+					ttyDenied[key] = ttyDenied[key] || denied
+				}
+			}
+			sessions = append(sessions, s)
+			keys = append(keys, key)
+		}
+	}
+
+	lastCPUActive := t.recorder.update(cpuTicks, now)
+	for i := range sessions {
+		s := &sessions[i]
+		lastActive := latest(lastCPUActive[keys[i]].changedAt, &s.StartTime, s.LastInput, s.LastOutput)
+		setIdleSeconds(s, lastActive, now, ttyDenied[keys[i]])
+		s.AgeSeconds = int64(now.Sub(s.StartTime).Seconds())
+	}
+	return sessions, nil
+}
+
+// readLoginRecords returns the active login records in utmp of the root directory of the process,
+// or in wtmp if utmp does not exist.
+func (t *Tracker) readLoginRecords(pid int) ([]utmp.Record, error) {
+	records, err := utmp.ReadFile(t.fs.RootPath(pid, utmp.UtmpPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		records, err = utmp.ReadFile(t.fs.RootPath(pid, utmp.WtmpPath))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login records of process %d: %w", pid, err)
+	}
+	return utmp.Active(records), nil
+}
@@ -0,0 +1,164 @@
+// Package utmp provides functions to read the login records in utmp and wtmp files.
+package utmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+// UtmpPath is the default path of the utmp file, which records the current logins.
+const UtmpPath = "/var/run/utmp"
+
+// WtmpPath is the default path of the wtmp file, which records the history of logins and logouts.
+const WtmpPath = "/var/log/wtmp"
+
+// The types of the records. See utmp(5).
+const (
+	TypeEmpty        = 0
+	TypeRunLevel     = 1
+	TypeBootTime     = 2
+	TypeNewTime      = 3
+	TypeOldTime      = 4
+	TypeInitProcess  = 5
+	TypeLoginProcess = 6
+	TypeUserProcess  = 7
+	TypeDeadProcess  = 8
+	TypeAccounting   = 9
+)
+
+// RecordSize is the size of a record written by glibc on 64-bit Linux.
+const RecordSize = 384
+
+// ErrBrokenUtmp is returned when a utmp file cannot be parsed.
+var ErrBrokenUtmp = errors.New("broken utmp")
+
+// Record represents a record in a utmp or wtmp file.
+type Record struct {
+	// Type is the type of the record such as TypeUserProcess.
+	Type int16
+	// PID is the PID of the login process.
+	PID int32
+	// Line is the device name of the terminal without "/dev/" such as "pts/0".
+	Line string
+	// ID is the terminal name suffix or inittab ID.
+	ID string
+	// User is the username.
+	User string
+	// Host is the hostname for remote login.
+	Host string
+	// Session is the session ID.
+	Session int32
+	// Time is the time the record was written.
+	Time time.Time
+	// Addr is the IP address of the remote host. It is nil if unknown.
+	Addr net.IP
+}
+
+// rawRecord is the binary layout of struct utmp on 64-bit Linux.
+// The time is stored in 32-bit fields for compatibility with 32-bit programs.
+type rawRecord struct {
+	Type     int16
+	_        [2]byte
+	PID      int32
+	Line     [32]byte
+	ID       [4]byte
+	User     [32]byte
+	Host     [256]byte
+	Exit     [2]int16
+	Session  int32
+	Sec      int32
+	Usec     int32
+	AddrV6   [16]byte
+	Reserved [20]byte
+}
+
+// ReadFile reads a utmp or wtmp file.
+func ReadFile(path string) ([]Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse parses the content of a utmp or wtmp file.
+func Parse(data []byte) ([]Record, error) {
+	if len(data)%RecordSize != 0 {
+		return nil, fmt.Errorf("%w: size %d is not a multiple of %d", ErrBrokenUtmp, len(data), RecordSize)
+	}
+	res := make([]Record, 0, len(data)/RecordSize)
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		var raw rawRecord
+		if err := binary.Read(r, binary.LittleEndian, &raw); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBrokenUtmp, err)
+		}
+		res = append(res, Record{
+			Type:    raw.Type,
+			PID:     raw.PID,
+			Line:    cString(raw.Line[:]),
+			ID:      cString(raw.ID[:]),
+			User:    cString(raw.User[:]),
+			Host:    cString(raw.Host[:]),
+			Session: raw.Session,
+			Time:    time.Unix(int64(uint32(raw.Sec)), int64(raw.Usec)*int64(time.Microsecond)),
+			Addr:    parseAddr(raw.AddrV6),
+		})
+	}
+	return res, nil
+}
+
+// Active returns the user processes that are still logged in, replaying the records in order.
+// For a utmp file, it returns the user process records.
+// For a wtmp file, the logins are closed by the dead process records of the same line and by the boot records.
+func Active(records []Record) []Record {
+	// logins maps the lines to the indices of the records.
+	logins := make(map[string]int)
+	for i, r := range records {
+		switch r.Type {
+		case TypeBootTime:
+			clear(logins)
+		case TypeUserProcess:
+			logins[r.Line] = i
+		case TypeDeadProcess:
+			delete(logins, r.Line)
+		}
+	}
+
+	indices := make([]int, 0, len(logins))
+	for _, i := range logins {
+		indices = append(indices, i)
+	}
+	slices.Sort(indices)
+	res := make([]Record, 0, len(indices))
+	for _, i := range indices {
+		res = append(res, records[i])
+	}
+	return res
+}
+
+// cString returns the string in a NUL-padded field. The field is not terminated if the string fills it.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// parseAddr returns the IP address in ut_addr_v6.
+// An IPv4 address is stored in the first 4 bytes and the rest are zero.
+func parseAddr(b [16]byte) net.IP {
+	if b == [16]byte{} {
+		return nil
+	}
+	if bytes.Equal(b[4:], make([]byte, 12)) {
+		return net.IPv4(b[0], b[1], b[2], b[3])
+	}
+	return net.IP(bytes.Clone(b[:]))
+}
@@ -0,0 +1,128 @@
+package utmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// encode returns the binary representation of the records.
+func encode(t *testing.T, records ...rawRecord) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	for _, r := range records {
+		if err := binary.Write(buf, binary.LittleEndian, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func raw(typ int16, pid int32, line, user, host string, sec int32) rawRecord {
+	r := rawRecord{Type: typ, PID: pid, Sec: sec}
+	copy(r.Line[:], line)
+	copy(r.User[:], user)
+	copy(r.Host[:], host)
+	return r
+}
+
+func TestParse(t *testing.T) {
+	r := raw(TypeUserProcess, 100, "pts/0", "alice", "192.0.2.10", 1700001000)
+	copy(r.ID[:], "ts/0")
+	r.Usec = 500000
+	r.Session = 100
+	copy(r.AddrV6[:], []byte{192, 0, 2, 10})
+	r6 := raw(TypeUserProcess, 101, "pts/1", "bob", "2001:db8::1", 1700002000)
+	copy(r6.AddrV6[:], net.ParseIP("2001:db8::1"))
+	// a field filled to the end is not terminated by NUL
+	long := raw(TypeUserProcess, 102, "pts/2", "abcdefghijklmnopqrstuvwxyz012345", "", 1700003000)
+
+	records, err := Parse(encode(t, r, r6, long))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+
+	got := records[0]
+	if got.Type != TypeUserProcess || got.PID != 100 || got.Line != "pts/0" || got.ID != "ts/0" ||
+		got.User != "alice" || got.Host != "192.0.2.10" || got.Session != 100 {
+		t.Errorf("unexpected record: %+v", got)
+	}
+	if want := time.Unix(1700001000, 500000000); !got.Time.Equal(want) {
+		t.Errorf("unexpected time: %v", got.Time)
+	}
+	if !got.Addr.Equal(net.ParseIP("192.0.2.10")) {
+		t.Errorf("unexpected address: %v", got.Addr)
+	}
+	if !records[1].Addr.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("unexpected address: %v", records[1].Addr)
+	}
+	if records[2].User != "abcdefghijklmnopqrstuvwxyz012345" || records[2].Addr != nil {
+		t.Errorf("unexpected record: %+v", records[2])
+	}
+
+	_, err = Parse(make([]byte, RecordSize+1))
+	if !errors.Is(err, ErrBrokenUtmp) {
+		t.Errorf("expected ErrBrokenUtmp, but got %v", err)
+	}
+}
+
+func TestActive(t *testing.T) {
+	testCases := []struct {
+		name    string
+		records []rawRecord
+		want    []int32
+	}{
+		{
+			name: "utmp",
+			records: []rawRecord{
+				raw(TypeBootTime, 0, "~", "reboot", "", 1700000000),
+				raw(TypeLoginProcess, 10, "tty1", "LOGIN", "", 1700000001),
+				raw(TypeUserProcess, 100, "pts/0", "alice", "", 1700001000),
+				raw(TypeDeadProcess, 101, "pts/1", "", "", 1700001500),
+				raw(TypeUserProcess, 102, "pts/2", "bob", "", 1700002000),
+			},
+			want: []int32{100, 102},
+		},
+		{
+			name: "wtmp",
+			records: []rawRecord{
+				raw(TypeUserProcess, 50, "pts/0", "alice", "", 1600000000),
+				raw(TypeBootTime, 0, "~", "reboot", "", 1700000000),
+				raw(TypeUserProcess, 100, "pts/0", "alice", "", 1700001000),
+				raw(TypeUserProcess, 101, "pts/1", "bob", "", 1700001100),
+				raw(TypeDeadProcess, 100, "pts/0", "", "", 1700001200),
+				raw(TypeUserProcess, 102, "pts/0", "carol", "", 1700001300),
+			},
+			want: []int32{101, 102},
+		},
+		{
+			name:    "empty",
+			records: []rawRecord{},
+			want:    []int32{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			records, err := Parse(encode(t, tc.records...))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			active := Active(records)
+			if len(active) != len(tc.want) {
+				t.Fatalf("unexpected records: %+v", active)
+			}
+			for i, pid := range tc.want {
+				if active[i].PID != pid {
+					t.Errorf("unexpected record: %+v", active[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package local_session_tracker
+
+import (
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+// Watch returns the latest status and a channel closed when the state changes.
+// If no scan has been done yet, it scans the processes.
+// The state changes only by the scans, so ScanInterval should be configured to watch the changes.
+func (t *Tracker) Watch() (*common.TTYStatus, <-chan struct{}, error) {
+	t.mu.Lock()
+	res, changed := t.latest, t.changed
+	t.mu.Unlock()
+	if res != nil {
+		return res, changed, nil
+	}
+
+	if _, err := t.Scan(); err != nil {
+		return nil, nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latest, t.changed, nil
+}
+
+// changes returns the channel closed when the state changes.
+func (t *Tracker) changes() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.changed
+}
+
+// stateChanged returns true if the state in the status has changed, that is the sessions, the holds, the error,
+// or the last activity of a session. The ages and the idle times increasing without activity are not the changes.
+func stateChanged(prev, cur *common.TTYStatus) bool {
+	if prev.Total != cur.Total || prev.Error != cur.Error ||
+		len(prev.Sessions) != len(cur.Sessions) || len(prev.Holds) != len(cur.Holds) {
+		return true
+	}
+	for i := range cur.Sessions {
+		p, c := &prev.Sessions[i], &cur.Sessions[i]
+		if p.ID != c.ID || p.Kind != c.Kind || len(p.Processes) != len(c.Processes) || p.IdleUnknown != c.IdleUnknown {
+			return true
+		}
+		// the session whose idle time is unknown is always active.
+		if c.IdleUnknown {
+			continue
+		}
+		// IdleSeconds is truncated, so the difference within a second is ignored.
+		if d := lastActive(prev, p).Sub(lastActive(cur, c)); d >= time.Second || d <= -time.Second {
+			return true
+		}
+	}
+	for i := range cur.Holds {
+		if prev.Holds[i].ID != cur.Holds[i].ID {
+			return true
+		}
+	}
+	return false
+}
+
+// lastActive returns the time the session was active for the last time.
+// It is later than the actual time by less than a second since IdleSeconds is truncated.
+func lastActive(res *common.TTYStatus, s *common.Session) time.Time {
+	return res.ScannedAt.Add(-time.Duration(s.IdleSeconds) * time.Second)
+}
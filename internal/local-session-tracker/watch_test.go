@@ -0,0 +1,148 @@
+package local_session_tracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cybozu-go/login-protector/internal/common"
+)
+
+func TestStateChanged(t *testing.T) {
+	scannedAt := time.Unix(1700000000, 0)
+	base := func() *common.TTYStatus {
+		return &common.TTYStatus{
+			Total:     2,
+			ScannedAt: scannedAt,
+			Sessions: []common.Session{
+				{ID: "100", Kind: common.SessionKindTTY, Processes: make([]common.Process, 1), IdleSeconds: 10, AgeSeconds: 100},
+			},
+			Holds: []common.Hold{{ID: "abc"}},
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(*common.TTYStatus)
+		want   bool
+	}{
+		{
+			name:   "same",
+			modify: func(*common.TTYStatus) {},
+		},
+		{
+			name: "idle without activity",
+			modify: func(s *common.TTYStatus) {
+				s.ScannedAt = scannedAt.Add(5*time.Second + 500*time.Millisecond)
+				s.Sessions[0].IdleSeconds = 15
+				s.Sessions[0].AgeSeconds = 105
+			},
+		},
+		{
+			name: "activity",
+			modify: func(s *common.TTYStatus) {
+				s.ScannedAt = scannedAt.Add(5 * time.Second)
+				s.Sessions[0].IdleSeconds = 0
+			},
+			want: true,
+		},
+		{
+			name:   "new process",
+			modify: func(s *common.TTYStatus) { s.Sessions[0].Processes = make([]common.Process, 2) },
+			want:   true,
+		},
+		{
+			name:   "session replaced",
+			modify: func(s *common.TTYStatus) { s.Sessions[0].ID = "200" },
+			want:   true,
+		},
+		{
+			name:   "hold replaced",
+			modify: func(s *common.TTYStatus) { s.Holds[0].ID = "def" },
+			want:   true,
+		},
+		{
+			name:   "error",
+			modify: func(s *common.TTYStatus) { s.Error = ErrPIDNamespaceNotShared.Error() },
+			want:   true,
+		},
+		{
+			name: "idle time unknown",
+			modify: func(s *common.TTYStatus) {
+				s.Sessions[0].IdleSeconds = 0
+				s.Sessions[0].IdleUnknown = true
+			},
+			want: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cur := base()
+			tc.modify(cur)
+			if got := stateChanged(base(), cur); got != tc.want {
+				t.Errorf("unexpected result: %v", got)
+			}
+		})
+	}
+
+	// the session whose idle time is unknown does not change at every scan
+	prev, cur := base(), base()
+	for _, s := range []*common.TTYStatus{prev, cur} {
+		s.Sessions[0].IdleSeconds = 0
+		s.Sessions[0].IdleUnknown = true
+	}
+	cur.ScannedAt = scannedAt.Add(5 * time.Second)
+	if stateChanged(prev, cur) {
+		t.Error("unknown idle time should not be a change")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	procRoot := filepath.Join(t.TempDir(), "proc")
+	err := copyDir(procRoot, filepath.Join("testdata", "single-session", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker(Config{ProcRoot: procRoot, Simulated: true})
+
+	res, changed, err := tracker.Watch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Version != 1 || len(res.Sessions) != 1 {
+		t.Fatalf("unexpected status: %+v", res)
+	}
+
+	// the time passes without any change
+	err = os.WriteFile(filepath.Join(procRoot, "uptime"), []byte("2600.00 9000.00\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := tracker.Scan(); err != nil || res.Version != 1 {
+		t.Fatalf("unexpected result: %+v, %v", res, err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("changed without any change")
+	default:
+	}
+
+	// the session has been closed
+	if err := os.RemoveAll(filepath.Join(procRoot, "100")); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []string{"120", "121"} {
+		if err := os.RemoveAll(filepath.Join(procRoot, pid)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res, err := tracker.Scan(); err != nil || res.Version != 2 || len(res.Sessions) != 0 {
+		t.Fatalf("unexpected result: %+v, %v", res, err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not notified")
+	}
+}